@@ -0,0 +1,71 @@
+package dblock
+
+import "time"
+
+// Clock tells the time and creates the timers, for the lock expiry, the TTL and the retry backoffs.
+// The clients use SystemClock by default, set another one by WithClock, e.g. a fake clock in tests,
+// see the clocktest package.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// NewTimer creates a Timer sending the current time on its channel after at least d.
+	NewTimer(d time.Duration) Timer
+	// AfterFunc waits for d, then calls f in its own goroutine.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is the timer created by a Clock, like time.Timer.
+type Timer interface {
+	// C returns the channel on which the time is sent, nil for the timers created by AfterFunc.
+	C() <-chan time.Time
+	// Stop prevents the Timer from firing, returns false if it already fired or been stopped.
+	Stop() bool
+	// Reset changes the timer to fire after d, returns true if the timer had been active.
+	Reset(d time.Duration) bool
+}
+
+// SystemClock is the Clock of the time package.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTimer(d time.Duration) Timer { return systemTimer{t: time.NewTimer(d)} }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return systemTimer{t: time.AfterFunc(d, f)}
+}
+
+type systemTimer struct {
+	t *time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time        { return t.t.C }
+func (t systemTimer) Stop() bool                 { return t.t.Stop() }
+func (t systemTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+// OffsetClock returns a Clock ahead of clock by offset, or behind if negative,
+// e.g. to correct the skew between the host and the database server, when the lock expiry is computed by the client.
+func OffsetClock(clock Clock, offset time.Duration) Clock {
+	return offsetClock{Clock: clock, offset: offset}
+}
+
+type offsetClock struct {
+	Clock
+	offset time.Duration
+}
+
+func (c offsetClock) Now() time.Time { return c.Clock.Now().Add(c.offset) }
+
+// realClock reports whether the time of clock passes like the system time, so that the deadlines of ctx can be used.
+func realClock(clock Clock) bool {
+	switch c := clock.(type) {
+	case systemClock:
+		return true
+	case offsetClock:
+		return realClock(c.Clock)
+	default:
+		return false
+	}
+}
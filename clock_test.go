@@ -0,0 +1,24 @@
+package dblock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+	"github.com/bingoohuang/dblock/clocktest"
+)
+
+func TestOffsetClock(t *testing.T) {
+	fake := clocktest.NewFakeClock(time.Now())
+	clock := dblock.OffsetClock(fake, -time.Second)
+	if exp, got := fake.Now().Add(-time.Second), clock.Now(); !exp.Equal(got) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+
+	// the timers are of the underlying clock
+	timer := clock.NewTimer(time.Minute)
+	fake.Advance(time.Minute)
+	if exp, got := fake.Now(), <-timer.C(); !exp.Equal(got) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+}
@@ -0,0 +1,151 @@
+// Package clocktest provides a fake dblock.Clock whose time moves only when told to,
+// so that the tests of the lock expiry, the TTL and the retry backoffs run instantly and deterministically.
+package clocktest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+)
+
+// FakeClock is a dblock.Clock for the tests, the timers fire when the time is advanced past them.
+type FakeClock struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	now  time.Time
+	// timers are the pending timers.
+	timers []*fakeTimer
+}
+
+// NewFakeClock creates a FakeClock starting at now.
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Now returns the current time of the clock.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// NewTimer creates a Timer sending the time of the clock on its channel when advanced past d.
+func (c *FakeClock) NewTimer(d time.Duration) dblock.Timer {
+	t := &fakeTimer{clock: c, ch: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+// AfterFunc creates a Timer calling f when advanced past d, f is called by Advance before it returns.
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) dblock.Timer {
+	t := &fakeTimer{clock: c, f: f}
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the time forward by d, and fires the timers due in the order of their time.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	until := c.now.Add(d)
+	for len(c.timers) > 0 && !c.timers[0].at.After(until) {
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		c.now = t.at
+		c.mu.Unlock()
+
+		// fires without the lock, the timer may be reset by f.
+		t.fire(t.at)
+		c.mu.Lock()
+	}
+	c.now = until
+	c.mu.Unlock()
+}
+
+// Timers returns the number of the pending timers.
+func (c *FakeClock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.timers)
+}
+
+// WaitTimers blocks until at least n timers are pending,
+// e.g. to wait for Obtain to wait for the backoff in another goroutine before advancing the clock.
+func (c *FakeClock) WaitTimers(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for len(c.timers) < n {
+		c.cond.Wait()
+	}
+}
+
+// add adds t to the pending timers, ordered by the time, the earlier added first among the same time.
+func (c *FakeClock) add(t *fakeTimer) {
+	i := sort.Search(len(c.timers), func(i int) bool { return c.timers[i].at.After(t.at) })
+	c.timers = append(c.timers, nil)
+	copy(c.timers[i+1:], c.timers[i:])
+	c.timers[i] = t
+	c.cond.Broadcast()
+}
+
+// remove removes t from the pending timers, returns false if not pending.
+func (c *FakeClock) remove(t *fakeTimer) bool {
+	for i, p := range c.timers {
+		if p == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	at    time.Time
+	ch    chan time.Time
+	f     func()
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.ch }
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.remove(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	c := t.clock
+	c.mu.Lock()
+	active := c.remove(t)
+	now := c.now
+	if d > 0 {
+		t.at = now.Add(d)
+		c.add(t)
+	}
+	c.mu.Unlock()
+
+	if d <= 0 {
+		// fires at once like time.Timer, f runs in its own goroutine, since the caller may hold the locks f needs.
+		if t.f != nil {
+			go t.f()
+		} else {
+			t.fire(now)
+		}
+	}
+	return active
+}
+
+func (t *fakeTimer) fire(now time.Time) {
+	if t.f != nil {
+		t.f()
+		return
+	}
+	select {
+	case t.ch <- now:
+	default:
+	}
+}
@@ -0,0 +1,65 @@
+package clocktest_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bingoohuang/dblock/clocktest"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2023, 8, 2, 23, 15, 5, 0, time.UTC)
+	clock := clocktest.NewFakeClock(start)
+
+	var fired []string
+	clock.AfterFunc(2*time.Second, func() { fired = append(fired, "2s") })
+	clock.AfterFunc(time.Second, func() { fired = append(fired, "1s") })
+	stopped := clock.AfterFunc(time.Second, func() { fired = append(fired, "stopped") })
+	timer := clock.NewTimer(3 * time.Second)
+	if !stopped.Stop() {
+		t.Fatal("expected the pending timer to be stopped")
+	}
+	if exp, got := 3, clock.Timers(); exp != got {
+		t.Fatalf("expected %d timers, got %d", exp, got)
+	}
+
+	clock.Advance(2 * time.Second)
+	if exp := []string{"1s", "2s"}; !reflect.DeepEqual(exp, fired) {
+		t.Fatalf("expected %q, got %q", exp, fired)
+	}
+	select {
+	case <-timer.C():
+		t.Fatal("expected the timer not to fire before 3s")
+	default:
+	}
+
+	// reset to fire at 4s
+	if !timer.Reset(2 * time.Second) {
+		t.Fatal("expected the timer to be active")
+	}
+	clock.Advance(time.Second)
+	clock.Advance(time.Second)
+	if exp, got := start.Add(4*time.Second), <-timer.C(); !exp.Equal(got) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	if exp, got := start.Add(4*time.Second), clock.Now(); !exp.Equal(got) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	if timer.Stop() {
+		t.Fatal("expected the fired timer not to be active")
+	}
+}
+
+func TestFakeClock_WaitTimers(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Now())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-clock.NewTimer(time.Minute).C()
+	}()
+
+	clock.WaitTimers(1)
+	clock.Advance(time.Minute)
+	<-done
+}
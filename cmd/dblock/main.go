@@ -4,13 +4,13 @@ import (
 	"context"
 	"flag"
 	"log"
+	"log/slog"
 	"os"
 	"time"
 
 	"github.com/bingoohuang/dblock"
 	"github.com/bingoohuang/dblock/pkg/envflag"
 	"github.com/bingoohuang/dblock/pkg/helper"
-	"github.com/bingoohuang/dblock/rdblock"
 	_ "github.com/go-sql-driver/mysql"
 )
 
@@ -37,19 +37,29 @@ var (
 	pRelease = flag.Bool("release", false, "release lock")
 	pRefresh = flag.Bool("refresh", false, "refresh lock")
 	pView    = flag.Bool("view", false, "view lock")
+	pList    = flag.Bool("list", false, "list held locks")
+	pPrefix  = flag.String("prefix", "", "key prefix of the locks to list")
+	pForce   = flag.Bool("force-release", false, "force release lock held by anyone")
+	pSteal   = flag.Bool("steal", false, "steal lock held by anyone")
+	pReason  = flag.String("reason", "", "reason of force-release or steal, recorded in meta")
+	pYes     = flag.Bool("yes", false, "confirm force-release or steal")
 	pDebug   = flag.Bool("debug", false, "debugging mode")
 )
 
 func main() {
 	_ = envflag.Parse()
 
-	if *pKey == "" || *pURI == "" {
+	if (*pKey == "" && !*pList) || *pURI == "" {
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	rdblock.Debug = *pDebug
-	locker, err := helper.Create(*pURI)
+	var optionsFns []dblock.ClientOptionsFn
+	if *pDebug {
+		handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+		optionsFns = append(optionsFns, dblock.WithLogger(slog.New(handler)))
+	}
+	locker, err := helper.Create(*pURI, optionsFns...)
 	if err != nil {
 		log.Fatalf("create lock: %v", err)
 	}
@@ -84,6 +94,23 @@ func main() {
 		} else {
 			log.Printf("view: %s", lockView)
 		}
+	case *pForce || *pSteal:
+		admin(ctx, locker)
+	case *pList:
+		lister, ok := locker.(dblock.Lister)
+		if !ok {
+			log.Printf("list is not supported")
+			return
+		}
+		views, err := lister.List(ctx, *pPrefix)
+		if err != nil {
+			log.Printf("list failed: %v", err)
+			return
+		}
+		for _, view := range views {
+			log.Printf("lock: %s", view)
+		}
+		log.Printf("%d locks held", len(views))
 	default:
 		if _, err := getLock(ctx, locker, *pKey, *pToken, *pMeta, *pTTL); err != nil {
 			return
@@ -91,6 +118,41 @@ func main() {
 	}
 }
 
+func admin(ctx context.Context, locker dblock.Client) {
+	a, ok := locker.(dblock.Admin)
+	if !ok {
+		log.Printf("force-release or steal is not supported")
+		return
+	}
+	if *pReason == "" {
+		log.Printf("-reason is required for force-release or steal")
+		return
+	}
+	if lockView, err := locker.View(ctx, *pKey); err == nil {
+		log.Printf("view: %s", lockView)
+	}
+	if !*pYes {
+		log.Printf("add -yes to confirm, the present holder will lose the lock")
+		return
+	}
+
+	if *pForce {
+		if err := a.ForceRelease(ctx, *pKey, *pReason); err != nil {
+			log.Printf("force release failed: %v", err)
+		} else {
+			log.Printf("force release successfully")
+		}
+		return
+	}
+
+	lock, err := a.Steal(ctx, *pKey, *pTTL, *pReason, dblock.WithToken(*pToken))
+	if err != nil {
+		log.Printf("steal failed: %v", err)
+		return
+	}
+	log.Printf("stolen, token: %s, meta: %s", lock.Token(), lock.Metadata())
+}
+
 func getLock(ctx context.Context, locker dblock.Client, key, token, meta string, ttl time.Duration) (dblock.Lock, error) {
 	lock, err := locker.Obtain(ctx, key, ttl, dblock.WithToken(token), dblock.WithMeta(meta))
 	if err != nil {
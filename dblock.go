@@ -4,7 +4,10 @@ package dblock
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
+	"log/slog"
+	"sync/atomic"
 	"time"
 )
 
@@ -17,10 +20,14 @@ var (
 
 	// ErrNoProviders is returned when trying to obtain a lock.
 	ErrNoProviders = errors.New("dblock: no providers registered")
+
+	// ErrLockLost is the cause of the lock context when the lock is lost.
+	ErrLockLost = errors.New("dblock: lock lost")
 )
 
 // Client abstracts the distributed lock.
 type Client interface {
+	// View returns the view of the lock of the key, Exists is false if the lock is not held.
 	View(ctx context.Context, key string) (LockView, error)
 
 	// Obtain tries to obtain a new lock using a key with the given TTL.
@@ -28,22 +35,119 @@ type Client interface {
 	Obtain(ctx context.Context, key string, ttl time.Duration, optionsFns ...OptionsFn) (Lock, error)
 }
 
+// RWClient abstracts the distributed read-write lock.
+type RWClient interface {
+	// ObtainShared tries to obtain a shared lock using a key with the given TTL,
+	// many shared holders can hold the key at once, while no exclusive lock is held.
+	// May return ErrNotObtained if not successful.
+	ObtainShared(ctx context.Context, key string, ttl time.Duration, optionsFns ...OptionsFn) (Lock, error)
+
+	// ObtainExclusive tries to obtain an exclusive lock using a key with the given TTL,
+	// it blocks new shared holders at once, and waits until the present shared holders are gone.
+	// May return ErrNotObtained if not successful.
+	ObtainExclusive(ctx context.Context, key string, ttl time.Duration, optionsFns ...OptionsFn) (Lock, error)
+}
+
+// Semaphore abstracts the distributed counting semaphore.
+type Semaphore interface {
+	// AcquirePermit tries to acquire one of the limit permits of the key with the given TTL,
+	// each permit has its own token and TTL, and is released like a Lock.
+	// May return ErrNotObtained if not successful.
+	AcquirePermit(ctx context.Context, key string, limit int, ttl time.Duration, optionsFns ...OptionsFn) (Lock, error)
+}
+
+// MultiClient abstracts the distributed lock of multiple keys.
+type MultiClient interface {
+	// ObtainMulti tries to obtain the locks of all the keys with the given TTL, or none of them.
+	// May return ErrNotObtained if not successful.
+	ObtainMulti(ctx context.Context, keys []string, ttl time.Duration, optionsFns ...OptionsFn) (MultiLock, error)
+}
+
+// MultiLock represents the obtained locks of multiple keys, which act as a whole.
+type MultiLock interface {
+	// Keys returns the keys of the locks.
+	Keys() []string
+
+	// Token returns the token value set by the locks.
+	Token() string
+
+	// Metadata returns the metadata of the locks.
+	Metadata() string
+
+	// TTL returns the minimum remaining time-to-live. Returns 0 if any lock has expired.
+	TTL(ctx context.Context) (time.Duration, error)
+	// Refresh extends all the locks with a new TTL.
+	// May return ErrNotObtained if refresh is unsuccessful.
+	Refresh(ctx context.Context, ttl time.Duration) error
+	// Release manually releases all the locks.
+	// May return ErrLockNotHeld if any lock is not held.
+	Release(ctx context.Context) error
+
+	// Done returns a channel that's closed when any lock is lost.
+	Done() <-chan struct{}
+	// Context returns a copy of parent which is cancelled when any lock is lost.
+	Context(parent context.Context) (context.Context, context.CancelFunc)
+}
+
 // ClientCloser abstracts the distributed lock that can be closed.
 type ClientCloser interface {
 	Client
 	io.Closer
 }
 
-// LockView is the view of a lock for viewing.
-type LockView interface {
-	// GetToken returns the token value set by the lock.
-	GetToken() string
+// Admin manages the locks without knowing their tokens, e.g. when the holder died with a long TTL.
+// The operator and the reason are recorded in the metadata.
+type Admin interface {
+	// ForceRelease releases the lock of the key whoever holds it.
+	// May return ErrLockNotHeld if the lock is not held.
+	ForceRelease(ctx context.Context, key, reason string) error
+	// Steal takes over the lock of the key with the given TTL whoever holds it.
+	Steal(ctx context.Context, key string, ttl time.Duration, reason string, optionsFns ...OptionsFn) (Lock, error)
+}
+
+// Lister lists the locks of a backend.
+type Lister interface {
+	// List returns the views of the held locks whose keys start with the prefix.
+	List(ctx context.Context, prefix string) ([]LockView, error)
+}
+
+// LockView is the view of a lock, every backend fills in the same fields.
+type LockView struct {
+	// Key is the key of the lock.
+	Key string
+	// Exists reports whether the lock is held, i.e. it exists and has not expired.
+	Exists bool
+
+	// Token is the token value set by the lock.
+	Token string
+	// Metadata is the metadata of the lock.
+	Metadata string
+
+	// AcquiredAt is when the lock is obtained.
+	AcquiredAt time.Time
+	// ExpiresAt is when the lock expires, zero if it never expires.
+	ExpiresAt time.Time
+
+	// Holder is the process holding the lock.
+	Holder Holder
+}
+
+// Holder is the process holding a lock.
+type Holder struct {
+	Host string
+	PID  int
+}
 
-	// GetMetadata returns the metadata of the lock.
-	GetMetadata() string
+func (v LockView) String() string {
+	return fmt.Sprintf("{Key: %s Exists: %t Token: %s Metadata: %s AcquiredAt: %s ExpiresAt: %s Host: %s PID: %d}",
+		v.Key, v.Exists, v.Token, v.Metadata, formatTime(v.AcquiredAt), formatTime(v.ExpiresAt), v.Holder.Host, v.Holder.PID)
+}
 
-	// GetUntil returns expired time in RFC3339.
-	GetUntil() string
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339Nano)
 }
 
 // Lock represents an obtained, distributed lock.
@@ -54,6 +158,10 @@ type Lock interface {
 	// Metadata returns the metadata of the lock.
 	Metadata() string
 
+	// Fence returns the fencing token, which increases on every successful Obtain of the key.
+	// Pass it to the storage layer to reject writes from a stale holder.
+	Fence() uint64
+
 	// TTL returns the remaining time-to-live. Returns 0 if the lock has expired.
 	TTL(ctx context.Context) (time.Duration, error)
 	// Refresh extends the lock with a new TTL.
@@ -62,6 +170,28 @@ type Lock interface {
 	// Release manually releases the lock.
 	// May return ErrLockNotHeld.
 	Release(ctx context.Context) error
+
+	// Done returns a channel that's closed when the lock is lost,
+	// i.e. the refreshing failed, the TTL passed, or the lock is released.
+	Done() <-chan struct{}
+	// Context returns a copy of parent which is cancelled when the lock is lost.
+	Context(parent context.Context) (context.Context, context.CancelFunc)
+}
+
+// HoldCounter is implemented by the locks counting the holds of a reentrant lock,
+// the wrappers of the locks, e.g. by ListenObtain, forward it.
+type HoldCounter interface {
+	// Holds returns the hold count of the key when the lock is obtained, it is greater than 1
+	// when a reentrant lock is obtained again by its owner.
+	Holds() int
+}
+
+// Holds returns the hold count of the lock if it implements HoldCounter, otherwise 1.
+func Holds(lock any) int {
+	if h, ok := lock.(HoldCounter); ok {
+		return h.Holds()
+	}
+	return 1
 }
 
 // Options describe the options for the lock.
@@ -70,12 +200,44 @@ type Options struct {
 	// Default: do not retry
 	RetryStrategy RetryStrategy
 
-	// Meta string.
+	// Meta string, a plain string set by WithMeta, or JSON set by WithMetadata or WithMetaValue.
 	Meta string
 
 	// Token is a unique value that is used to identify the lock. By default, a random tokens are generated. Use this
 	// option to provide a custom token instead.
 	Token string
+
+	// Reentrant allows the owner of the token to obtain the held lock again with a hold count,
+	// a matching number of Release calls is needed to free it.
+	Reentrant bool
+
+	// AutoRefresh is the interval to refresh the obtained lock in background.
+	// Default: 0, do not refresh automatically.
+	AutoRefresh time.Duration
+
+	// RefreshFailed is called when the background refreshing fails.
+	RefreshFailed func(err error)
+
+	// Fair grants the lock to the waiters in their arrival order,
+	// all the contenders of a key should obtain it in the fair mode.
+	Fair bool
+
+	// QueuePosition is called with the position of the waiter in the fair queue when it changes, 0 is the head.
+	QueuePosition func(position int)
+
+	// WaitTimeout is the maximum time to wait for the lock, independent of the TTL.
+	// Default: 0, wait within the TTL when the ctx has no deadline.
+	WaitTimeout time.Duration
+
+	// Blocking waits for the lock until the ctx is done, independent of the TTL.
+	Blocking bool
+
+	// Clock is the clock of the client set by WithClock, for the lock expiry and the retry backoffs.
+	// Default: SystemClock
+	Clock Clock
+
+	// err is the error of the options, returned by ParseOptions.
+	err error
 }
 
 // OptionsFn allows to customise the lock retry strategy.
@@ -102,10 +264,285 @@ func WithToken(token string) OptionsFn {
 	}
 }
 
-// GetRetryStrategy returns the retry strategy.
+// WithReentrant set the lock reentrant for the owner of the token.
+func WithReentrant() OptionsFn {
+	return func(options *Options) {
+		options.Reentrant = true
+	}
+}
+
+// WithAutoRefresh set the interval to refresh the obtained lock in background,
+// the interval should be less than the TTL of the lock.
+func WithAutoRefresh(interval time.Duration) OptionsFn {
+	return func(options *Options) {
+		options.AutoRefresh = interval
+	}
+}
+
+// WithRefreshFailed set the callback for failed background refreshing.
+func WithRefreshFailed(f func(err error)) OptionsFn {
+	return func(options *Options) {
+		options.RefreshFailed = f
+	}
+}
+
+// WithFair set the fair mode, the waiters are granted the lock in their arrival order.
+func WithFair() OptionsFn {
+	return func(options *Options) {
+		options.Fair = true
+	}
+}
+
+// WithQueuePosition set the callback for the position changes of the waiter in the fair queue.
+func WithQueuePosition(f func(position int)) OptionsFn {
+	return func(options *Options) {
+		options.QueuePosition = f
+	}
+}
+
+// WithWaitTimeout set the maximum time to wait for the lock, independent of the TTL, the ctx deadline still applies.
+// It is a deadline of the attempts, context.DeadlineExceeded is returned when it passes.
+// A retry strategy with jitter is used if not set.
+func WithWaitTimeout(timeout time.Duration) OptionsFn {
+	return func(options *Options) {
+		options.WaitTimeout = timeout
+	}
+}
+
+// WithBlocking set the blocking mode, which waits for the lock until the ctx is done, independent of the TTL.
+// A retry strategy with jitter is used if not set.
+func WithBlocking() OptionsFn {
+	return func(options *Options) {
+		options.Blocking = true
+	}
+}
+
+// ParseOptions applies the optionsFns, and creates a random token if not set.
+func ParseOptions(optionsFns ...OptionsFn) (*Options, error) {
+	opt := &Options{Clock: SystemClock}
+	for _, f := range optionsFns {
+		f(opt)
+	}
+	if opt.err != nil {
+		return nil, opt.err
+	}
+
+	// Create a random token
+	if opt.Token == "" {
+		var err error
+		if opt.Token, err = RandomToken(); err != nil {
+			return nil, err
+		}
+	}
+
+	return opt, nil
+}
+
+// DefaultWaitStrategy is the retry strategy used when waiting for the lock by WithWaitTimeout or WithBlocking,
+// without a retry strategy set.
+var DefaultWaitStrategy = FullJitter(16*time.Millisecond, time.Second)
+
+// GetRetryStrategy returns the retry strategy, which stops retrying when the WaitTimeout passed.
 func (o *Options) GetRetryStrategy() RetryStrategy {
-	if o.RetryStrategy != nil {
-		return o.RetryStrategy
+	strategy := o.RetryStrategy
+	if strategy == nil {
+		strategy = NoRetry()
+		if o.WaitTimeout > 0 || o.Blocking {
+			strategy = DefaultWaitStrategy
+		}
+	}
+	if o.WaitTimeout > 0 {
+		strategy = MaxElapsed(strategy, o.WaitTimeout)
+	}
+	return strategy
+}
+
+// GetWaitTimeout returns the wait passed to Retry, the ttl by default,
+// or 0 to wait until the ctx is done in the blocking mode or with WaitTimeout, which is the deadline set by Options.Retry.
+func (o *Options) GetWaitTimeout(ttl time.Duration) time.Duration {
+	if o.Blocking || o.WaitTimeout > 0 {
+		return 0
+	}
+	return ttl
+}
+
+// Retry calls Retry with the clock, the wait and the retry strategy of the options,
+// the attempts are cut off by the deadline of WaitTimeout if set, in addition to the ctx deadline.
+func (o *Options) Retry(ctx context.Context, ttl time.Duration, obtain func(ctx context.Context) (bool, error)) error {
+	return o.RetryWake(ctx, ttl, nil, obtain)
+}
+
+// RetryWake is like Retry, but calls RetryWake with wake.
+func (o *Options) RetryWake(ctx context.Context, ttl time.Duration, wake func(ctx context.Context) <-chan struct{},
+	obtain func(ctx context.Context) (bool, error),
+) error {
+	clock := o.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+	if o.WaitTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = withTimeout(ctx, clock, o.WaitTimeout)
+		defer cancel()
+	}
+	return RetryWake(ctx, clock, o.GetWaitTimeout(ttl), o.GetRetryStrategy(), wake, obtain)
+}
+
+// WaiterTTL wraps the retry strategy of the options to record the backoffs, and returns how long a waiter of a fair lock
+// is kept in the queue after an attempt: 3 times the last backoff to cover the growing ones, at most the ttl,
+// and the ttl before the first backoff. The clients call it before the retries of a fair lock.
+func (o *Options) WaiterTTL(ttl time.Duration) func() time.Duration {
+	var last atomic.Int64
+	s := o.GetRetryStrategy()
+	o.RetryStrategy = RetryStrategyFunc(func() Retrier {
+		retrier := s.NewRetrier()
+		return RetrierFunc(func(state RetryState) time.Duration {
+			backoff := retrier.NextBackoff(state)
+			last.Store(int64(backoff))
+			return backoff
+		})
+	})
+
+	return func() time.Duration {
+		if wait := 3 * time.Duration(last.Load()); wait > 0 && wait < ttl {
+			return wait
+		}
+		return ttl
+	}
+}
+
+// ObtainDrained obtains the lock by a single attempt of obtain with the options, and then waits until drained reports true,
+// e.g. the shared holders are gone, all in the retries of the options. The lock is refreshed while waiting,
+// obtained again if lost, and released if not drained. The clients call it in their ObtainExclusive,
+// and keep the waiter of a fair lock queued in obtain between the attempts, until obtained or given up.
+func (o *Options) ObtainDrained(ctx context.Context, ttl time.Duration, obtain func(ctx context.Context, opt *Options) (Lock, error),
+	drained func(ctx context.Context) (bool, error),
+) (Lock, error) {
+	once := *o
+	once.RetryStrategy, once.WaitTimeout, once.Blocking = NoRetry(), 0, false
+
+	var lock Lock
+	var refresher *Refresher
+	drop := func() {
+		if refresher != nil {
+			refresher.Stop()
+			refresher = nil
+		}
+		lock = nil
+	}
+	err := o.Retry(ctx, ttl, func(ctx context.Context) (bool, error) {
+		if lock != nil {
+			select {
+			case <-lock.Done():
+				drop()
+			default:
+			}
+		}
+		if lock == nil {
+			// the attempt is traced by the retries here
+			l, err := obtain(context.WithValue(ctx, retryTraceKey{}, (*RetryTrace)(nil)), &once)
+			if errors.Is(err, ErrNotObtained) {
+				return false, nil
+			} else if err != nil {
+				return false, err
+			}
+			lock = l
+			if o.AutoRefresh <= 0 {
+				refresher = StartRefresher(o.Clock, lock, ttl, 0, nil)
+			}
+		}
+		return drained(ctx)
+	})
+	if refresher != nil {
+		refresher.Stop()
+	}
+	if err == nil {
+		// restart the lease after waiting
+		err = lock.Refresh(ctx, ttl)
+	}
+	if err != nil {
+		if lock != nil {
+			_ = lock.Release(context.Background())
+		}
+		return nil, err
+	}
+	return lock, nil
+}
+
+// ClientOptions describe the options for the clients.
+type ClientOptions struct {
+	// Listener receives the events of the locks obtained by Obtain and ObtainMulti.
+	Listener Listener
+
+	// Logger logs the operations of the client, with the key, token prefix, attempt number and duration.
+	// Default: nil, do not log.
+	Logger *slog.Logger
+
+	// RedactMetadata replaces the metadata with RedactedMetadata in the logs.
+	RedactMetadata bool
+
+	// Clock tells the time for the lock expiry, the TTL and the retry backoffs.
+	// Default: SystemClock
+	Clock Clock
+}
+
+// ClientOptionsFn allows to customise the client.
+type ClientOptionsFn func(*ClientOptions)
+
+// WithListener set the listener of the lock events.
+func WithListener(listener Listener) ClientOptionsFn {
+	return func(options *ClientOptions) {
+		options.Listener = listener
+	}
+}
+
+// WithLogger set the logger of the client, e.g. slog.New(handler) to send the logs to a JSON log pipeline.
+func WithLogger(logger *slog.Logger) ClientOptionsFn {
+	return func(options *ClientOptions) {
+		options.Logger = logger
+	}
+}
+
+// WithRedactMetadata set to redact the metadata in the logs.
+func WithRedactMetadata() ClientOptionsFn {
+	return func(options *ClientOptions) {
+		options.RedactMetadata = true
+	}
+}
+
+// WithClock set the clock of the client, e.g. a fake clock in tests, see the clocktest package.
+func WithClock(clock Clock) ClientOptionsFn {
+	return func(options *ClientOptions) {
+		options.Clock = clock
+	}
+}
+
+// ParseClientOptions applies the optionsFns.
+func ParseClientOptions(optionsFns ...ClientOptionsFn) ClientOptions {
+	opt := ClientOptions{Clock: SystemClock}
+	for _, f := range optionsFns {
+		f(&opt)
+	}
+	return opt
+}
+
+// ParseOptions applies the optionsFns like ParseOptions, with the clock of the client set.
+func (o ClientOptions) ParseOptions(optionsFns ...OptionsFn) (*Options, error) {
+	return ParseOptions(o.withClock(optionsFns)...)
+}
+
+// getClock returns the clock of the client, SystemClock if not set.
+func (o ClientOptions) getClock() Clock {
+	if o.Clock == nil {
+		return SystemClock
+	}
+	return o.Clock
+}
+
+// withClock prepends the option setting the clock of the client to optionsFns.
+func (o ClientOptions) withClock(optionsFns []OptionsFn) []OptionsFn {
+	if o.Clock == nil {
+		return optionsFns
 	}
-	return NoRetry()
+	return append([]OptionsFn{func(options *Options) { options.Clock = o.Clock }}, optionsFns...)
 }
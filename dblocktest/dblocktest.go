@@ -0,0 +1,164 @@
+// Package dblocktest provides an in-memory dblock.Client for the tests of the packages built on the locks,
+// the locks expire by the clock of the client, e.g. a clocktest.FakeClock set by dblock.WithClock.
+package dblocktest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+)
+
+// Client is an in-memory dblock.Client, the locks are kept in the process.
+type Client struct {
+	mu      sync.Mutex
+	options dblock.ClientOptions
+	locks   map[string]*lock
+	fences  map[string]uint64
+}
+
+// NewClient creates a new Client, the clock, the logger and the listener of the options are used like the other clients.
+func NewClient(optionsFns ...dblock.ClientOptionsFn) *Client {
+	return &Client{
+		options: dblock.ParseClientOptions(optionsFns...),
+		locks:   map[string]*lock{},
+		fences:  map[string]uint64{},
+	}
+}
+
+// View returns the view of the lock of the key, Exists is false if the lock is not held.
+func (c *Client) View(_ context.Context, key string) (dblock.LockView, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	l, ok := c.locks[key]
+	if !ok {
+		return dblock.LockView{Key: key}, nil
+	}
+	return dblock.LockView{
+		Key: key, Exists: c.now().Before(l.until), Token: l.token, Metadata: l.meta,
+		AcquiredAt: l.at, ExpiresAt: l.until,
+	}, nil
+}
+
+// Held reports whether the lock of the key is held.
+func (c *Client) Held(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	l, ok := c.locks[key]
+	return ok && c.now().Before(l.until)
+}
+
+// Obtain tries to obtain a new lock using a key with the given TTL.
+// May return ErrNotObtained if not successful.
+func (c *Client) Obtain(ctx context.Context, key string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
+	return c.options.Obtain(ctx, c.obtainLock, key, ttl, optionsFns...)
+}
+
+func (c *Client) obtainLock(ctx context.Context, key string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
+	opt, err := c.options.ParseOptions(optionsFns...)
+	if err != nil {
+		return nil, err
+	}
+
+	var l *lock
+	err = opt.Retry(ctx, ttl, func(context.Context) (bool, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if cur, ok := c.locks[key]; ok && cur.token != opt.Token && c.now().Before(cur.until) {
+			return false, nil
+		}
+
+		c.fences[key]++
+		now := c.now()
+		until := now.Add(ttl)
+		l = &lock{
+			client: c, key: key, token: opt.Token, meta: opt.Meta, fence: c.fences[key], at: now, until: until,
+			lifetime: dblock.NewLifetime(opt.Clock, until),
+		}
+		c.locks[key] = l
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if opt.AutoRefresh > 0 {
+		l.refresher = dblock.StartRefresher(opt.Clock, l, ttl, opt.AutoRefresh, opt.RefreshFailed)
+	}
+	return l, nil
+}
+
+func (c *Client) now() time.Time { return c.options.Clock.Now() }
+
+// lock is an obtained lock of the Client.
+type lock struct {
+	client    *Client
+	key       string
+	token     string
+	meta      string
+	fence     uint64
+	at        time.Time
+	until     time.Time
+	lifetime  *dblock.Lifetime
+	refresher *dblock.Refresher
+}
+
+func (l *lock) Token() string    { return l.token }
+func (l *lock) Metadata() string { return l.meta }
+func (l *lock) Fence() uint64    { return l.fence }
+
+// owned reports whether the lock is still held by l, the client must be locked.
+func (l *lock) owned() bool {
+	cur, ok := l.client.locks[l.key]
+	return ok && cur.token == l.token && l.client.now().Before(cur.until)
+}
+
+func (l *lock) TTL(context.Context) (time.Duration, error) {
+	l.client.mu.Lock()
+	defer l.client.mu.Unlock()
+
+	if !l.owned() {
+		return 0, nil
+	}
+	return l.client.locks[l.key].until.Sub(l.client.now()), nil
+}
+
+func (l *lock) Refresh(_ context.Context, ttl time.Duration) error {
+	l.client.mu.Lock()
+	defer l.client.mu.Unlock()
+
+	if !l.owned() {
+		l.lifetime.Lose()
+		return dblock.ErrNotObtained
+	}
+	until := l.client.now().Add(ttl)
+	l.client.locks[l.key].until = until
+	l.lifetime.Extend(until)
+	return nil
+}
+
+func (l *lock) Release(context.Context) error {
+	if l.refresher != nil {
+		l.refresher.Stop()
+	}
+	defer l.lifetime.Lose()
+
+	l.client.mu.Lock()
+	defer l.client.mu.Unlock()
+
+	if !l.owned() {
+		return dblock.ErrLockNotHeld
+	}
+	delete(l.client.locks, l.key)
+	return nil
+}
+
+func (l *lock) Done() <-chan struct{} { return l.lifetime.Done() }
+
+func (l *lock) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	return l.lifetime.Context(parent)
+}
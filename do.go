@@ -0,0 +1,38 @@
+package dblock
+
+import (
+	"context"
+	"time"
+)
+
+// Do obtains the lock of the key, and runs fn with a context which is cancelled when the lock is lost.
+// The lock is refreshed in background while fn runs, and always released after, even on panic.
+// The DefaultClient is used when client is nil.
+func Do(ctx context.Context, client Client, key string, ttl time.Duration, fn func(ctx context.Context) error, optionsFns ...OptionsFn) (err error) {
+	if client == nil {
+		client = DefaultClient
+	}
+	if client == nil {
+		return ErrNoProviders
+	}
+
+	optionsFns = append([]OptionsFn{WithAutoRefresh(ttl / 3)}, optionsFns...)
+	lock, err := client.Obtain(ctx, key, ttl, optionsFns...)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		releaseCtx, cancel := context.WithTimeout(context.Background(), ttl)
+		defer cancel()
+
+		if releaseErr := lock.Release(releaseCtx); err == nil {
+			err = releaseErr
+		}
+	}()
+
+	lockCtx, cancel := lock.Context(ctx)
+	defer cancel()
+
+	return fn(lockCtx)
+}
@@ -0,0 +1,88 @@
+package dblock_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+	"github.com/bingoohuang/dblock/clocktest"
+	"github.com/bingoohuang/dblock/dblocktest"
+)
+
+func TestDo(t *testing.T) {
+	ctx := context.Background()
+	clock := clocktest.NewFakeClock(time.Now())
+	client := dblocktest.NewClient(dblock.WithClock(clock))
+	listener := newRecordListener()
+
+	// outlive the TTL, the lock is kept by the auto refreshing every 10ms
+	err := dblock.Do(ctx, dblock.ListenClient(client, listener), "key", 30*time.Millisecond, func(ctx context.Context) error {
+		for i := 1; i <= 10; i++ {
+			clock.Advance(10 * time.Millisecond)
+			// obtained, and refreshed i times
+			listener.wait(1 + i)
+		}
+		if !client.Held("key") {
+			t.Fatal("expected the lock to be held while running")
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if client.Held("key") {
+		t.Fatal("expected the lock to be released")
+	}
+}
+
+func TestDo_notObtained(t *testing.T) {
+	ctx := context.Background()
+	client := dblocktest.NewClient()
+
+	if _, err := client.Obtain(ctx, "key", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	err := dblock.Do(ctx, client, "key", time.Hour, func(context.Context) error {
+		t.Fatal("expected fn not to run")
+		return nil
+	})
+	if exp, got := dblock.ErrNotObtained, err; !errors.Is(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+}
+
+func TestDo_panic(t *testing.T) {
+	client := dblocktest.NewClient()
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected the panic to be propagated")
+		}
+		if client.Held("key") {
+			t.Fatal("expected the lock to be released on panic")
+		}
+	}()
+
+	_ = dblock.Do(context.Background(), client, "key", time.Hour, func(context.Context) error {
+		panic("boom")
+	})
+}
+
+func TestDo_defaultClient(t *testing.T) {
+	defer func(c dblock.Client) { dblock.DefaultClient = c }(dblock.DefaultClient)
+
+	dblock.DefaultClient = nil
+	err := dblock.Do(context.Background(), nil, "key", time.Hour, func(context.Context) error { return nil })
+	if exp, got := dblock.ErrNoProviders, err; !errors.Is(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+
+	dblock.DefaultClient = dblocktest.NewClient()
+	if err := dblock.Do(context.Background(), nil, "key", time.Hour, func(context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+}
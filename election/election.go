@@ -0,0 +1,192 @@
+// Package election elects one leader among a set of replicas with a dblock lock.
+package election
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+)
+
+var (
+	// ErrNoLeader is returned by Leader when there is no leader elected.
+	ErrNoLeader = errors.New("election: no leader")
+	// ErrNotLeader is returned by Resign when the election is not won.
+	ErrNotLeader = errors.New("election: not leader")
+)
+
+// Election is an election of the leader on the key.
+type Election struct {
+	// Clock tells the time of observing the leader, dblock.SystemClock is used if nil,
+	// it should be the same as the clock of the client.
+	Clock dblock.Clock
+
+	client     dblock.Client
+	key        string
+	ttl        time.Duration
+	optionsFns []dblock.OptionsFn
+
+	mu   sync.Mutex
+	lock dblock.Lock
+}
+
+// New creates a new Election on the key, the term of the leader is renewed every ttl/3,
+// and the leadership is taken over by others within ttl after the leader is gone.
+func New(client dblock.Client, key string, ttl time.Duration, optionsFns ...dblock.OptionsFn) *Election {
+	return &Election{client: client, key: key, ttl: ttl, optionsFns: optionsFns}
+}
+
+// Campaign blocks until it is elected as the leader with the value published, or the ctx is done.
+// The term is renewed in background until Resign is called or the leadership is lost.
+// Done and Resign do not wait for a campaign in progress.
+func (e *Election) Campaign(ctx context.Context, value string) error {
+	if e.leading() {
+		return nil
+	}
+
+	token, err := dblock.RandomToken()
+	if err != nil {
+		return err
+	}
+
+	// block until elected, the retrying is not limited within the ttl.
+	optionsFns := append([]dblock.OptionsFn{dblock.WithRetryStrategy(dblock.LinearBackoff(e.ttl / 4))}, e.optionsFns...)
+	optionsFns = append(optionsFns, dblock.WithToken(token), dblock.WithMeta(value), dblock.WithAutoRefresh(e.ttl/3),
+		dblock.WithBlocking())
+	lock, err := e.client.Obtain(ctx, e.key, e.ttl, optionsFns...)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.lock != nil && !isDone(e.lock) {
+		// elected by another campaign at the same time
+		_ = lock.Release(ctx)
+		return nil
+	}
+	e.lock = lock
+	return nil
+}
+
+// leading reports whether the election is won and the leadership is not lost.
+func (e *Election) leading() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.lock != nil && isDone(e.lock) {
+		e.lock = nil
+	}
+	return e.lock != nil
+}
+
+func isDone(lock dblock.Lock) bool {
+	select {
+	case <-lock.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// Done returns a channel that's closed when the leadership is lost or resigned.
+// The channel is closed already if the election is not won.
+func (e *Election) Done() <-chan struct{} {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.lock == nil {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+	return e.lock.Done()
+}
+
+// Resign gives up the leadership, so that others can be elected.
+// May return ErrNotLeader if the election is not won.
+func (e *Election) Resign(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.lock == nil {
+		return ErrNotLeader
+	}
+
+	lock := e.lock
+	e.lock = nil
+	if err := lock.Release(ctx); errors.Is(err, dblock.ErrLockNotHeld) {
+		return ErrNotLeader
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Leader returns the value published by the present leader.
+// May return ErrNoLeader if there is no leader.
+func (e *Election) Leader(ctx context.Context) (string, error) {
+	view, err := e.leader(ctx)
+	if err != nil {
+		return "", err
+	}
+	return view.Metadata, nil
+}
+
+// leader returns the lock view of the present leader.
+func (e *Election) leader(ctx context.Context) (dblock.LockView, error) {
+	view, err := e.client.View(ctx, e.key)
+	if err != nil {
+		return dblock.LockView{}, err
+	}
+	if !view.Exists {
+		return dblock.LockView{}, ErrNoLeader
+	}
+	return view, nil
+}
+
+// Observe returns a channel which receives the value of the leader every time the leadership changes,
+// an empty value means there is no leader. The channel is closed when the ctx is done.
+func (e *Election) Observe(ctx context.Context) <-chan string {
+	ch := make(chan string)
+	go e.observe(ctx, ch)
+	return ch
+}
+
+func (e *Election) observe(ctx context.Context, ch chan<- string) {
+	defer close(ch)
+
+	clock := e.Clock
+	if clock == nil {
+		clock = dblock.SystemClock
+	}
+	timer := clock.NewTimer(e.ttl / 4)
+	defer timer.Stop()
+
+	var last dblock.LockView
+	first := true
+	for {
+		view, err := e.leader(ctx)
+		if err == nil || errors.Is(err, ErrNoLeader) {
+			// compare with the token, a new leader may publish the same value.
+			if first || view.Token != last.Token || view.Metadata != last.Metadata {
+				select {
+				case <-ctx.Done():
+					return
+				case ch <- view.Metadata:
+				}
+				last, first = view, false
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C():
+			timer.Reset(e.ttl / 4)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package election_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bingoohuang/dblock/dblocktest"
+	"github.com/bingoohuang/dblock/election"
+)
+
+func TestElection(t *testing.T) {
+	ctx := context.Background()
+	client := dblocktest.NewClient()
+
+	e1 := election.New(client, "leader", 100*time.Millisecond)
+	e2 := election.New(client, "leader", 100*time.Millisecond)
+
+	if _, err := e1.Leader(ctx); !errors.Is(err, election.ErrNoLeader) {
+		t.Fatalf("expected %v, got %v", election.ErrNoLeader, err)
+	}
+	if err := e1.Campaign(ctx, "node1"); err != nil {
+		t.Fatal(err)
+	}
+	if leader, err := e2.Leader(ctx); err != nil || leader != "node1" {
+		t.Fatalf("expected node1, got %q, %v", leader, err)
+	}
+
+	// the term is renewed in background
+	campaignCtx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer cancel()
+	if err := e2.Campaign(campaignCtx, "node2"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+
+	observeCtx, cancelObserve := context.WithCancel(ctx)
+	defer cancelObserve()
+	observed := e2.Observe(observeCtx)
+	if leader := <-observed; leader != "node1" {
+		t.Fatalf("expected node1, got %q", leader)
+	}
+
+	if err := e1.Resign(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := e1.Resign(ctx); !errors.Is(err, election.ErrNotLeader) {
+		t.Fatalf("expected %v, got %v", election.ErrNotLeader, err)
+	}
+	if leader := <-observed; leader != "" {
+		t.Fatalf("expected no leader, got %q", leader)
+	}
+
+	if err := e2.Campaign(ctx, "node2"); err != nil {
+		t.Fatal(err)
+	}
+	defer e2.Resign(ctx)
+	if leader := <-observed; leader != "node2" {
+		t.Fatalf("expected node2, got %q", leader)
+	}
+}
+
+func TestElection_Campaign_nonBlocking(t *testing.T) {
+	ctx := context.Background()
+	client := dblocktest.NewClient()
+
+	holder, err := client.Obtain(ctx, "leader", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Release(ctx)
+
+	e := election.New(client, "leader", time.Hour)
+	campaignCtx, cancel := context.WithCancel(ctx)
+	campaigned := make(chan error, 1)
+	go func() { campaigned <- e.Campaign(campaignCtx, "node1") }()
+
+	// Done and Resign return at once while campaigning
+	select {
+	case <-e.Done():
+	case <-time.After(time.Second):
+		t.Fatal("expected Done not to block")
+	}
+	if err := e.Resign(ctx); !errors.Is(err, election.ErrNotLeader) {
+		t.Fatalf("expected %v, got %v", election.ErrNotLeader, err)
+	}
+
+	cancel()
+	if err := <-campaigned; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+}
@@ -0,0 +1,65 @@
+package dblock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Lifetime tracks the ownership of an obtained lock.
+type Lifetime struct {
+	mu     sync.Mutex
+	clock  Clock
+	timer  Timer
+	done   chan struct{}
+	closed bool
+}
+
+// NewLifetime creates a Lifetime for a lock held until the given time of the clock.
+func NewLifetime(clock Clock, until time.Time) *Lifetime {
+	l := &Lifetime{clock: clock, done: make(chan struct{})}
+	// the timer may fire at once, Lose waits for it to be set.
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.timer = clock.AfterFunc(until.Sub(clock.Now()), l.Lose)
+	return l
+}
+
+// Extend extends the lifetime after the lock refreshed successfully.
+func (l *Lifetime) Extend(until time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.closed {
+		l.timer.Reset(until.Sub(l.clock.Now()))
+	}
+}
+
+// Lose marks the lock lost, e.g. the refreshing failed, the TTL passed, or it is released.
+func (l *Lifetime) Lose() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.closed {
+		l.closed = true
+		l.timer.Stop()
+		close(l.done)
+	}
+}
+
+// Done returns a channel that's closed when the lock is lost.
+func (l *Lifetime) Done() <-chan struct{} { return l.done }
+
+// Context returns a copy of parent which is cancelled with ErrLockLost when the lock is lost.
+func (l *Lifetime) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(parent)
+	go func() {
+		select {
+		case <-l.done:
+			cancel(ErrLockLost)
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() { cancel(context.Canceled) }
+}
@@ -0,0 +1,47 @@
+package dblock_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+	"github.com/bingoohuang/dblock/clocktest"
+)
+
+func TestLifetime_expired(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Now())
+	l := dblock.NewLifetime(clock, clock.Now().Add(20*time.Millisecond))
+	ctx, cancel := l.Context(context.Background())
+	defer cancel()
+
+	clock.Advance(20 * time.Millisecond)
+	select {
+	case <-l.Done():
+	default:
+		t.Fatal("expected the lifetime to be done after the TTL passed")
+	}
+
+	<-ctx.Done()
+	if exp, got := dblock.ErrLockLost, context.Cause(ctx); !errors.Is(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+}
+
+func TestLifetime_extend(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Now())
+	l := dblock.NewLifetime(clock, clock.Now().Add(20*time.Millisecond))
+	l.Extend(clock.Now().Add(time.Hour))
+
+	clock.Advance(50 * time.Millisecond)
+	select {
+	case <-l.Done():
+		t.Fatal("expected the extended lifetime not to be done")
+	default:
+	}
+
+	l.Lose()
+	l.Lose()
+	<-l.Done()
+}
@@ -0,0 +1,148 @@
+package dblock
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Listener receives the events of the locks, e.g. to emit audit events and alerts when a lock is lost or contended.
+// The callbacks are called synchronously, and should return quickly.
+type Listener interface {
+	// OnObtain is called when the lock of the key is obtained.
+	OnObtain(key string, lock Lock)
+	// OnObtainFailed is called when the lock of the key cannot be obtained, e.g. with ErrNotObtained.
+	OnObtainFailed(key string, err error)
+	// OnRetry is called before waiting for the backoff to retry the contended lock of the key, attempt starts from 1.
+	OnRetry(key string, attempt int, backoff time.Duration)
+	// OnRefresh is called when the lock of the key is refreshed with the ttl.
+	OnRefresh(key string, ttl time.Duration)
+	// OnRefreshFailed is called when the refreshing of the lock of the key fails.
+	OnRefreshFailed(key string, err error)
+	// OnRelease is called when the lock of the key is released.
+	OnRelease(key string)
+	// OnExpired is called when the lock of the key is lost before released, i.e. the TTL passed or the refreshing failed.
+	OnExpired(key string)
+}
+
+// NopListener ignores all the events, embed it to implement only some of the callbacks.
+type NopListener struct{}
+
+func (NopListener) OnObtain(string, Lock)              {}
+func (NopListener) OnObtainFailed(string, error)       {}
+func (NopListener) OnRetry(string, int, time.Duration) {}
+func (NopListener) OnRefresh(string, time.Duration)    {}
+func (NopListener) OnRefreshFailed(string, error)      {}
+func (NopListener) OnRelease(string)                   {}
+func (NopListener) OnExpired(string)                   {}
+
+// ObtainFunc is the signature of Client.Obtain.
+type ObtainFunc func(ctx context.Context, key string, ttl time.Duration, optionsFns ...OptionsFn) (Lock, error)
+
+// ListenClient wraps the client to report the events of the locks obtained by Obtain to the listener.
+func ListenClient(client Client, listener Listener) Client {
+	return &listenedClient{Client: client, listener: listener}
+}
+
+type listenedClient struct {
+	Client
+	listener Listener
+}
+
+func (c *listenedClient) Obtain(ctx context.Context, key string, ttl time.Duration, optionsFns ...OptionsFn) (Lock, error) {
+	return ListenObtain(ctx, c.listener, c.Client.Obtain, key, ttl, optionsFns...)
+}
+
+// ListenObtain calls obtain, and reports the events of the obtaining and the obtained lock to the listener.
+// The background refreshing is taken over to report the refreshes.
+func ListenObtain(ctx context.Context, listener Listener, obtain ObtainFunc, key string, ttl time.Duration,
+	optionsFns ...OptionsFn,
+) (Lock, error) {
+	var autoRefresh time.Duration
+	var refreshFailed func(err error)
+	var clock Clock
+	optionsFns = append(optionsFns, func(options *Options) {
+		options.RetryStrategy = &listenedRetry{s: options.GetRetryStrategy(), key: key, listener: listener}
+		autoRefresh, refreshFailed, clock = options.AutoRefresh, options.RefreshFailed, options.Clock
+		options.AutoRefresh, options.RefreshFailed = 0, nil
+	})
+
+	lock, err := obtain(ctx, key, ttl, optionsFns...)
+	if err != nil {
+		listener.OnObtainFailed(key, err)
+		return nil, err
+	}
+
+	listener.OnObtain(key, lock)
+	l := &listenedLock{Lock: lock, key: key, listener: listener}
+	go l.watch()
+	if autoRefresh > 0 {
+		l.refresher = StartRefresher(clock, l, ttl, autoRefresh, refreshFailed)
+	}
+	return l, nil
+}
+
+// listenedRetry reports the retries of the strategy.
+type listenedRetry struct {
+	s        RetryStrategy
+	key      string
+	listener Listener
+}
+
+func (r *listenedRetry) NewRetrier() Retrier {
+	retrier := r.s.NewRetrier()
+	return RetrierFunc(func(state RetryState) time.Duration {
+		backoff := retrier.NextBackoff(state)
+		if backoff > 0 {
+			r.listener.OnRetry(r.key, state.Attempt, backoff)
+		}
+		return backoff
+	})
+}
+
+type listenedLock struct {
+	Lock
+	key       string
+	listener  Listener
+	refresher *Refresher
+	// settled is set when the release or the expiry is reported, only one of them is reported.
+	settled atomic.Bool
+}
+
+// watch reports the lock expired if it is lost before released.
+func (l *listenedLock) watch() {
+	<-l.Lock.Done()
+	if !l.settled.Swap(true) {
+		l.listener.OnExpired(l.key)
+	}
+}
+
+func (l *listenedLock) Holds() int { return Holds(l.Lock) }
+
+func (l *listenedLock) Refresh(ctx context.Context, ttl time.Duration) error {
+	if err := l.Lock.Refresh(ctx, ttl); err != nil {
+		l.listener.OnRefreshFailed(l.key, err)
+		return err
+	}
+	l.listener.OnRefresh(l.key, ttl)
+	return nil
+}
+
+func (l *listenedLock) Release(ctx context.Context) error {
+	if l.refresher != nil {
+		l.refresher.Stop()
+	}
+
+	// settle before releasing, the lock is lost after released.
+	settled := l.settled.Swap(true)
+	err := l.Lock.Release(ctx)
+	switch {
+	case settled:
+	case err == nil:
+		l.listener.OnRelease(l.key)
+	case errors.Is(err, ErrLockNotHeld):
+		l.listener.OnExpired(l.key)
+	}
+	return err
+}
@@ -0,0 +1,242 @@
+package dblock_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+	"github.com/bingoohuang/dblock/clocktest"
+	"github.com/bingoohuang/dblock/dblocktest"
+)
+
+// recordListener records the events as strings.
+type recordListener struct {
+	mu     sync.Mutex
+	events []string
+	// added receives when an event is recorded.
+	added chan struct{}
+}
+
+func newRecordListener() *recordListener {
+	return &recordListener{added: make(chan struct{}, 100)}
+}
+
+func (r *recordListener) add(format string, args ...any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, fmt.Sprintf(format, args...))
+	r.added <- struct{}{}
+}
+
+// wait waits until n events are recorded.
+func (r *recordListener) wait(n int) {
+	for len(r.get()) < n {
+		<-r.added
+	}
+}
+
+func (r *recordListener) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+func (r *recordListener) OnObtain(key string, _ dblock.Lock) { r.add("obtain %s", key) }
+func (r *recordListener) OnObtainFailed(key string, err error) {
+	r.add("obtain failed %s: %v", key, err)
+}
+
+func (r *recordListener) OnRetry(key string, attempt int, backoff time.Duration) {
+	r.add("retry %s: %d %v", key, attempt, backoff)
+}
+func (r *recordListener) OnRefresh(key string, ttl time.Duration) { r.add("refresh %s: %v", key, ttl) }
+func (r *recordListener) OnRefreshFailed(key string, err error) {
+	r.add("refresh failed %s: %v", key, err)
+}
+func (r *recordListener) OnRelease(key string) { r.add("release %s", key) }
+func (r *recordListener) OnExpired(key string) { r.add("expired %s", key) }
+
+func TestListenClient(t *testing.T) {
+	ctx := context.Background()
+	listener := newRecordListener()
+	client := dblock.ListenClient(dblocktest.NewClient(), listener)
+
+	lock, err := client.Obtain(ctx, "key", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Obtain(ctx, "key", time.Minute, dblock.WithRetryStrategy(dblock.LimitRetry(dblock.LinearBackoff(time.Millisecond), 2)))
+	if !errors.Is(err, dblock.ErrNotObtained) {
+		t.Fatalf("expected %v, got %v", dblock.ErrNotObtained, err)
+	}
+	if err := lock.Refresh(ctx, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{
+		"obtain key",
+		"retry key: 1 1ms",
+		"retry key: 2 1ms",
+		"obtain failed key: " + dblock.ErrNotObtained.Error(),
+		"refresh key: 1h0m0s",
+		"release key",
+	}
+	if got := listener.get(); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("expected %q, got %q", exp, got)
+	}
+}
+
+func TestClientOptions_ObtainMulti_listener(t *testing.T) {
+	ctx := context.Background()
+	listener := newRecordListener()
+	clock := clocktest.NewFakeClock(time.Now())
+	obtainMulti := func(optionsFns ...dblock.ClientOptionsFn) func(context.Context, []string, time.Duration, ...dblock.OptionsFn) (dblock.MultiLock, error) {
+		opts := dblock.ParseClientOptions(append(optionsFns, dblock.WithListener(listener))...)
+		client := dblocktest.NewClient(optionsFns...)
+		obtain := func(ctx context.Context, keys []string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.MultiLock, error) {
+			lock, err := client.Obtain(ctx, strings.Join(keys, ","), ttl, optionsFns...)
+			if err != nil {
+				return nil, err
+			}
+			return multiLock{Lock: lock, keys: keys}, nil
+		}
+		return func(ctx context.Context, keys []string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.MultiLock, error) {
+			return opts.ObtainMulti(ctx, obtain, keys, ttl, optionsFns...)
+		}
+	}
+
+	obtain := obtainMulti()
+	keys := []string{"a", "b"}
+	lock, err := obtain(ctx, keys, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = obtain(ctx, keys, time.Minute,
+		dblock.WithRetryStrategy(dblock.LimitRetry(dblock.LinearBackoff(time.Millisecond), 1)))
+	if !errors.Is(err, dblock.ErrNotObtained) {
+		t.Fatalf("expected %v, got %v", dblock.ErrNotObtained, err)
+	}
+	if err := lock.Refresh(ctx, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// lost before released
+	lock, err = obtainMulti(dblock.WithClock(clock))(ctx, keys, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(20 * time.Millisecond)
+	<-lock.Done()
+	listener.wait(7)
+
+	exp := []string{
+		"obtain a,b",
+		"retry a,b: 1 1ms",
+		"obtain failed a,b: " + dblock.ErrNotObtained.Error(),
+		"refresh a,b: 1h0m0s",
+		"release a,b",
+		"obtain a,b",
+		"expired a,b",
+	}
+	if got := listener.get(); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("expected %q, got %q", exp, got)
+	}
+}
+
+func TestListenClient_expired(t *testing.T) {
+	ctx := context.Background()
+	listener := newRecordListener()
+	clock := clocktest.NewFakeClock(time.Now())
+	client := dblock.ListenClient(dblocktest.NewClient(dblock.WithClock(clock)), listener)
+
+	lock, err := client.Obtain(ctx, "key", 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(20 * time.Millisecond)
+	<-lock.Done()
+	// reported in background
+	listener.wait(2)
+
+	if err := lock.Release(ctx); !errors.Is(err, dblock.ErrLockNotHeld) {
+		t.Fatalf("expected %v, got %v", dblock.ErrLockNotHeld, err)
+	}
+
+	exp := []string{"obtain key", "expired key"}
+	if got := listener.get(); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("expected %q, got %q", exp, got)
+	}
+}
+
+func TestListenClient_autoRefresh(t *testing.T) {
+	ctx := context.Background()
+	listener := newRecordListener()
+	clock := clocktest.NewFakeClock(time.Now())
+	client := dblock.ListenClient(dblocktest.NewClient(dblock.WithClock(clock)), listener)
+
+	lock, err := client.Obtain(ctx, "key", 100*time.Millisecond, dblock.WithAutoRefresh(30*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(50 * time.Millisecond)
+	// refreshed in background
+	listener.wait(2)
+	if err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{"obtain key", "refresh key: 100ms", "release key"}
+	if got := listener.get(); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("expected %q, got %q", exp, got)
+	}
+}
+
+// heldLock is a lock obtained again by its owner.
+type heldLock struct {
+	dblock.Lock
+}
+
+func (heldLock) Holds() int { return 2 }
+
+func TestListenObtain_holds(t *testing.T) {
+	ctx := context.Background()
+	client := dblocktest.NewClient()
+	obtain := func(ctx context.Context, key string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
+		lock, err := client.Obtain(ctx, key, ttl, optionsFns...)
+		if err != nil {
+			return nil, err
+		}
+		return heldLock{Lock: lock}, nil
+	}
+
+	lock, err := dblock.ListenObtain(ctx, dblock.NopListener{}, obtain, "key", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release(ctx)
+
+	// the hold count is forwarded by the listened lock, the locks not counting the holds have 1
+	if exp, got := 2, dblock.Holds(lock); exp != got {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	plain, err := client.Obtain(ctx, "other", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Release(ctx)
+	if exp, got := 1, dblock.Holds(plain); exp != got {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+}
@@ -0,0 +1,273 @@
+package dblock
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// RedactedMetadata replaces the metadata in the logs when the metadata is redacted.
+const RedactedMetadata = "[redacted]"
+
+// tokenPrefixLen is the length of the token prefix in the logs, enough to tell the holders apart.
+const tokenPrefixLen = 6
+
+// TokenPrefix returns the prefix of the token to log.
+func TokenPrefix(token string) string {
+	if len(token) > tokenPrefixLen {
+		return token[:tokenPrefixLen]
+	}
+	return token
+}
+
+// LogMetadata returns the metadata to log, RedactedMetadata if redacted and not empty.
+func (o ClientOptions) LogMetadata(meta string) string {
+	if o.RedactMetadata && meta != "" {
+		return RedactedMetadata
+	}
+	return meta
+}
+
+// Obtain calls obtain with the clock, the logger and the listener of the options, if any.
+// The clients call it in their Obtain.
+func (o ClientOptions) Obtain(ctx context.Context, obtain ObtainFunc, key string, ttl time.Duration,
+	optionsFns ...OptionsFn,
+) (Lock, error) {
+	optionsFns = o.withClock(optionsFns)
+	if o.Logger != nil {
+		logger, next := o.Logger.With("key", key), obtain
+		obtain = func(ctx context.Context, key string, ttl time.Duration, optionsFns ...OptionsFn) (Lock, error) {
+			l := &logListener{logger: logger, options: o, clock: o.getClock()}
+			l.start = l.clock.Now()
+			ctx = WithRetryTrace(ctx, &RetryTrace{
+				AttemptDone: l.attemptDone,
+				Wait:        l.wait,
+			})
+			return ListenObtain(ctx, l, next, key, ttl, optionsFns...)
+		}
+	}
+	if o.Listener != nil {
+		return ListenObtain(ctx, o.Listener, obtain, key, ttl, optionsFns...)
+	}
+	return obtain(ctx, key, ttl, optionsFns...)
+}
+
+// ObtainMultiFunc is the signature of MultiClient.ObtainMulti.
+type ObtainMultiFunc func(ctx context.Context, keys []string, ttl time.Duration, optionsFns ...OptionsFn) (MultiLock, error)
+
+// ObtainMulti calls obtain with the clock, the logger and the listener of the options, if any,
+// the events are logged with the keys, and reported to the listener with the keys joined by commas as the key.
+// The clients call it in their ObtainMulti.
+func (o ClientOptions) ObtainMulti(ctx context.Context, obtain ObtainMultiFunc, keys []string, ttl time.Duration,
+	optionsFns ...OptionsFn,
+) (MultiLock, error) {
+	optionsFns = o.withClock(optionsFns)
+	if o.Logger == nil && o.Listener == nil {
+		return obtain(ctx, keys, ttl, optionsFns...)
+	}
+
+	key := strings.Join(keys, ",")
+	var l *logListener
+	var listener listeners
+	if o.Logger != nil {
+		l = &logListener{logger: o.Logger.With("keys", keys), options: o, clock: o.getClock()}
+		l.start = l.clock.Now()
+		ctx = WithRetryTrace(ctx, &RetryTrace{
+			AttemptDone: l.attemptDone,
+			Wait:        l.wait,
+		})
+		listener = append(listener, l)
+	}
+	if o.Listener != nil {
+		listener = append(listener, o.Listener)
+	}
+	// take over the background refreshing to report the refreshes, like ListenObtain
+	var autoRefresh time.Duration
+	var refreshFailed func(err error)
+	optionsFns = append(optionsFns, func(options *Options) {
+		if o.Listener != nil {
+			options.RetryStrategy = &listenedRetry{s: options.GetRetryStrategy(), key: key, listener: o.Listener}
+		}
+		autoRefresh, refreshFailed = options.AutoRefresh, options.RefreshFailed
+		options.AutoRefresh, options.RefreshFailed = 0, nil
+	})
+
+	lock, err := obtain(ctx, keys, ttl, optionsFns...)
+	if err != nil {
+		listener.OnObtainFailed(key, err)
+		return nil, err
+	}
+
+	if l != nil {
+		l.obtained(lock.Token(), lock.Metadata())
+	}
+	if o.Listener != nil {
+		o.Listener.OnObtain(key, multiAsLock{lock})
+	}
+	m := &listenedMultiLock{MultiLock: lock, key: key, listener: listener}
+	go m.watch()
+	if autoRefresh > 0 {
+		m.refresher = StartRefresher(o.getClock(), m, ttl, autoRefresh, refreshFailed)
+	}
+	return m, nil
+}
+
+// multiAsLock presents the locks of multiple keys as a Lock to the listener, without a fencing token.
+type multiAsLock struct{ MultiLock }
+
+func (multiAsLock) Fence() uint64 { return 0 }
+
+// listeners reports the events to every listener.
+type listeners []Listener
+
+func (ls listeners) OnObtain(key string, lock Lock) {
+	for _, l := range ls {
+		l.OnObtain(key, lock)
+	}
+}
+
+func (ls listeners) OnObtainFailed(key string, err error) {
+	for _, l := range ls {
+		l.OnObtainFailed(key, err)
+	}
+}
+
+func (ls listeners) OnRetry(key string, attempt int, backoff time.Duration) {
+	for _, l := range ls {
+		l.OnRetry(key, attempt, backoff)
+	}
+}
+
+func (ls listeners) OnRefresh(key string, ttl time.Duration) {
+	for _, l := range ls {
+		l.OnRefresh(key, ttl)
+	}
+}
+
+func (ls listeners) OnRefreshFailed(key string, err error) {
+	for _, l := range ls {
+		l.OnRefreshFailed(key, err)
+	}
+}
+
+func (ls listeners) OnRelease(key string) {
+	for _, l := range ls {
+		l.OnRelease(key)
+	}
+}
+
+func (ls listeners) OnExpired(key string) {
+	for _, l := range ls {
+		l.OnExpired(key)
+	}
+}
+
+// listenedMultiLock reports the events of the locks of multiple keys, like listenedLock.
+type listenedMultiLock struct {
+	MultiLock
+	key       string
+	listener  Listener
+	refresher *Refresher
+	// settled is set when the release or the expiry is reported, only one of them is reported.
+	settled atomic.Bool
+}
+
+func (m *listenedMultiLock) watch() {
+	<-m.MultiLock.Done()
+	if !m.settled.Swap(true) {
+		m.listener.OnExpired(m.key)
+	}
+}
+
+func (m *listenedMultiLock) Holds() int { return Holds(m.MultiLock) }
+
+func (m *listenedMultiLock) Refresh(ctx context.Context, ttl time.Duration) error {
+	if err := m.MultiLock.Refresh(ctx, ttl); err != nil {
+		m.listener.OnRefreshFailed(m.key, err)
+		return err
+	}
+	m.listener.OnRefresh(m.key, ttl)
+	return nil
+}
+
+func (m *listenedMultiLock) Release(ctx context.Context) error {
+	if m.refresher != nil {
+		m.refresher.Stop()
+	}
+
+	settled := m.settled.Swap(true)
+	err := m.MultiLock.Release(ctx)
+	switch {
+	case settled:
+	case err == nil:
+		m.listener.OnRelease(m.key)
+	case errors.Is(err, ErrLockNotHeld):
+		m.listener.OnExpired(m.key)
+	}
+	return err
+}
+
+// logListener logs the events of a lock, the routine events at the debug level.
+type logListener struct {
+	logger  *slog.Logger
+	options ClientOptions
+	clock   Clock
+	start   time.Time
+	// attempt is the number of attempts, set by the retry trace before the lock is obtained.
+	attempt int
+	// the fields of the obtained lock
+	token      string
+	obtainedAt time.Time
+}
+
+func (l *logListener) attemptDone(attempt int, ok bool, err error) {
+	l.attempt = attempt
+	if !ok && err == nil {
+		l.logger.Debug("dblock: lock contended", "attempt", attempt, "duration", l.clock.Now().Sub(l.start))
+	}
+}
+
+func (l *logListener) wait(backoff time.Duration) {
+	l.logger.Debug("dblock: wait to retry", "attempt", l.attempt, "backoff", backoff)
+}
+
+func (l *logListener) OnObtain(_ string, lock Lock) {
+	l.obtained(lock.Token(), lock.Metadata(), "fence", lock.Fence())
+}
+
+func (l *logListener) obtained(token, meta string, args ...any) {
+	l.token, l.obtainedAt = TokenPrefix(token), l.clock.Now()
+	args = append([]any{"token", l.token}, args...)
+	l.logger.Info("dblock: lock obtained", append(args,
+		"metadata", l.options.LogMetadata(meta), "attempt", l.attempt, "duration", l.clock.Now().Sub(l.start))...)
+}
+
+func (l *logListener) OnObtainFailed(_ string, err error) {
+	level := slog.LevelError
+	if errors.Is(err, ErrNotObtained) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		level = slog.LevelInfo
+	}
+	l.logger.Log(context.Background(), level, "dblock: lock not obtained",
+		"attempt", l.attempt, "duration", l.clock.Now().Sub(l.start), "error", err)
+}
+
+func (l *logListener) OnRetry(string, int, time.Duration) {}
+
+func (l *logListener) OnRefresh(_ string, ttl time.Duration) {
+	l.logger.Debug("dblock: lock refreshed", "token", l.token, "ttl", ttl)
+}
+
+func (l *logListener) OnRefreshFailed(_ string, err error) {
+	l.logger.Warn("dblock: lock refresh failed", "token", l.token, "error", err)
+}
+
+func (l *logListener) OnRelease(string) {
+	l.logger.Info("dblock: lock released", "token", l.token, "duration", l.clock.Now().Sub(l.obtainedAt))
+}
+
+func (l *logListener) OnExpired(string) {
+	l.logger.Warn("dblock: lock lost", "token", l.token, "duration", l.clock.Now().Sub(l.obtainedAt))
+}
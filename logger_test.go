@@ -0,0 +1,120 @@
+package dblock_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+	"github.com/bingoohuang/dblock/dblocktest"
+)
+
+func TestClientOptions_logger(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	opts := dblock.ParseClientOptions(dblock.WithLogger(logger), dblock.WithRedactMetadata())
+
+	client := dblocktest.NewClient()
+	lock, err := opts.Obtain(ctx, client.Obtain, "key", time.Minute, dblock.WithToken("abcdefghij"), dblock.WithMeta("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = opts.Obtain(ctx, client.Obtain, "key", time.Minute, dblock.WithRetryStrategy(dblock.LimitRetry(dblock.LinearBackoff(time.Millisecond), 1)))
+	if !errors.Is(err, dblock.ErrNotObtained) {
+		t.Fatalf("expected %v, got %v", dblock.ErrNotObtained, err)
+	}
+	if err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var records []map[string]any
+	for dec := json.NewDecoder(&buf); dec.More(); {
+		var r map[string]any
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+
+	exp := []struct {
+		msg     string
+		attempt float64
+	}{
+		{msg: "dblock: lock obtained", attempt: 1},
+		{msg: "dblock: lock contended", attempt: 1},
+		{msg: "dblock: wait to retry", attempt: 1},
+		{msg: "dblock: lock contended", attempt: 2},
+		{msg: "dblock: lock not obtained", attempt: 2},
+		{msg: "dblock: lock released"},
+	}
+	if len(records) != len(exp) {
+		t.Fatalf("expected %d records, got %v", len(exp), records)
+	}
+	for i, e := range exp {
+		r := records[i]
+		if r["msg"] != e.msg || r["key"] != "key" {
+			t.Errorf("expected %s of key, got %v", e.msg, r)
+		}
+		if e.attempt > 0 && r["attempt"] != e.attempt {
+			t.Errorf("expected attempt %v, got %v", e.attempt, r)
+		}
+	}
+	if r := records[0]; r["token"] != "abcdef" || r["metadata"] != dblock.RedactedMetadata {
+		t.Errorf("expected token prefix and redacted metadata, got %v", r)
+	}
+}
+
+func TestClientOptions_ObtainMulti_logger(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	opts := dblock.ParseClientOptions(dblock.WithLogger(logger))
+
+	client := dblocktest.NewClient()
+	obtain := func(ctx context.Context, keys []string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.MultiLock, error) {
+		lock, err := client.Obtain(ctx, strings.Join(keys, ","), ttl, optionsFns...)
+		if err != nil {
+			return nil, err
+		}
+		return multiLock{Lock: lock, keys: keys}, nil
+	}
+	lock, err := opts.ObtainMulti(ctx, obtain, []string{"a", "b"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Refresh(ctx, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var msgs []string
+	for dec := json.NewDecoder(&buf); dec.More(); {
+		var r map[string]any
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		if keys, ok := r["keys"].([]any); !ok || len(keys) != 2 {
+			t.Errorf("expected the keys, got %v", r)
+		}
+		msgs = append(msgs, r["msg"].(string))
+	}
+	if exp := []string{"dblock: lock obtained", "dblock: lock refreshed", "dblock: lock released"}; !reflect.DeepEqual(exp, msgs) {
+		t.Fatalf("expected %v, got %v", exp, msgs)
+	}
+}
+
+type multiLock struct {
+	dblock.Lock
+	keys []string
+}
+
+func (l multiLock) Keys() []string { return l.keys }
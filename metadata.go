@@ -0,0 +1,59 @@
+package dblock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrPlainMetadata is returned when decoding the metadata which is a plain string set by WithMeta, not JSON.
+var ErrPlainMetadata = errors.New("dblock: metadata is not JSON")
+
+// WithMetadata set the metadata of key values, which is stored as a JSON object,
+// and can be decoded by DecodeMetadata or LockView.DecodeMetadata.
+func WithMetadata(m map[string]string) OptionsFn {
+	return WithMetaValue(m)
+}
+
+// WithMetaValue set the metadata of any value, which is stored as JSON,
+// and can be decoded by DecodeMetadata or LockView.DecodeMetadata.
+// ParseOptions returns the error if the value cannot be encoded.
+func WithMetaValue(v any) OptionsFn {
+	return func(options *Options) {
+		data, err := json.Marshal(v)
+		if err != nil {
+			options.err = fmt.Errorf("dblock: encode metadata: %w", err)
+			return
+		}
+		options.Meta = string(data)
+	}
+}
+
+// DecodeMetadata decodes the JSON metadata of the lock set by WithMetadata or WithMetaValue into v.
+// The plain string metadata set by WithMeta is decoded into a *string as it is,
+// and ErrPlainMetadata is returned for the other types of v.
+func DecodeMetadata(lock interface{ Metadata() string }, v any) error {
+	return decodeMetadata(lock.Metadata(), v)
+}
+
+// DecodeMetadata decodes the metadata of the view like the DecodeMetadata function.
+func (v LockView) DecodeMetadata(out any) error {
+	return decodeMetadata(v.Metadata, out)
+}
+
+func decodeMetadata(meta string, v any) error {
+	if meta == "" {
+		return nil
+	}
+	if s, ok := v.(*string); ok {
+		// a JSON string, or the plain string as it is
+		if err := json.Unmarshal([]byte(meta), s); err != nil {
+			*s = meta
+		}
+		return nil
+	}
+	if !json.Valid([]byte(meta)) {
+		return ErrPlainMetadata
+	}
+	return json.Unmarshal([]byte(meta), v)
+}
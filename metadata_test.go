@@ -0,0 +1,63 @@
+package dblock_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+	"github.com/bingoohuang/dblock/dblocktest"
+)
+
+func TestWithMetadata(t *testing.T) {
+	meta := map[string]string{"job": "42", "trace": "a\\b\"c"}
+	opt, err := dblock.ParseOptions(dblock.WithMetadata(meta))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]string
+	view := dblock.LockView{Metadata: opt.Meta}
+	if err := view.DecodeMetadata(&got); err != nil || !reflect.DeepEqual(meta, got) {
+		t.Fatalf("expected %v, got %v, %v", meta, got, err)
+	}
+}
+
+func TestWithMetaValue(t *testing.T) {
+	type build struct {
+		Version string
+		At      time.Time
+	}
+
+	meta := build{Version: "v1.2.3", At: time.Date(2023, 8, 2, 0, 0, 0, 0, time.UTC)}
+	client := dblocktest.NewClient()
+	lock, err := client.Obtain(context.Background(), "key", time.Minute, dblock.WithMetaValue(meta))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got build
+	if err := dblock.DecodeMetadata(lock, &got); err != nil || !meta.At.Equal(got.At) || meta.Version != got.Version {
+		t.Fatalf("expected %v, got %v, %v", meta, got, err)
+	}
+
+	if _, err := dblock.ParseOptions(dblock.WithMetaValue(func() {})); err == nil {
+		t.Fatal("expected encoding error")
+	}
+}
+
+func TestDecodeMetadata_plain(t *testing.T) {
+	view := dblock.LockView{Metadata: "my-data"}
+
+	var s string
+	if err := view.DecodeMetadata(&s); err != nil || s != "my-data" {
+		t.Fatalf("expected my-data, got %q, %v", s, err)
+	}
+
+	var m map[string]string
+	if err := view.DecodeMetadata(&m); !errors.Is(err, dblock.ErrPlainMetadata) {
+		t.Fatalf("expected %v, got %v", dblock.ErrPlainMetadata, err)
+	}
+}
@@ -0,0 +1,126 @@
+// Package metrics records the metrics of the locks obtained by any dblock.Client,
+// like the obtains, retries, refreshes and releases, and the wait time and hold time.
+package metrics
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+)
+
+// Metrics records the metrics of the locks, labelled by the backend and the key prefix.
+type Metrics interface {
+	// ObserveObtain records an obtaining of the lock with the time waited, err is nil if obtained.
+	ObserveObtain(backend, prefix string, wait time.Duration, err error)
+	// ObserveRetry records a retry of obtaining the contended lock.
+	ObserveRetry(backend, prefix string)
+	// ObserveRefresh records a refreshing of the lock, err is nil if refreshed.
+	ObserveRefresh(backend, prefix string, err error)
+	// ObserveRelease records the lock released, or lost before released if expired, with the time held.
+	ObserveRelease(backend, prefix string, hold time.Duration, expired bool)
+}
+
+// Options describe the options for the wrapped client.
+type Options struct {
+	// Prefix returns the key prefix used as the label of the key.
+	// Default: the part before the first colon, like "job" of "job:42", or the key itself without colons.
+	Prefix func(key string) string
+	// Clock tells the time of the wait time and the hold time.
+	// Default: dblock.SystemClock, it should be the same as the clock of the client.
+	Clock dblock.Clock
+}
+
+// OptionsFn allows to customise the wrapped client.
+type OptionsFn func(*Options)
+
+// WithPrefix set the function to get the key prefix from the key.
+func WithPrefix(prefix func(key string) string) OptionsFn {
+	return func(options *Options) {
+		options.Prefix = prefix
+	}
+}
+
+// WithClock set the clock to measure the wait time and the hold time.
+func WithClock(clock dblock.Clock) OptionsFn {
+	return func(options *Options) {
+		options.Clock = clock
+	}
+}
+
+// Prefix returns the part before the first colon of the key, or the key itself without colons.
+func Prefix(key string) string {
+	if i := strings.IndexByte(key, ':'); i >= 0 {
+		return key[:i]
+	}
+	return key
+}
+
+// Wrap wraps the client to record the metrics of the locks obtained by Obtain to m,
+// backend is the label of the client, like "redis" or "mysql".
+func Wrap(client dblock.Client, backend string, m Metrics, optionsFns ...OptionsFn) dblock.Client {
+	opt := Options{Prefix: Prefix, Clock: dblock.SystemClock}
+	for _, f := range optionsFns {
+		f(&opt)
+	}
+	return &wrappedClient{Client: client, backend: backend, metrics: m, prefix: opt.Prefix, clock: opt.Clock}
+}
+
+type wrappedClient struct {
+	dblock.Client
+	backend string
+	metrics Metrics
+	prefix  func(key string) string
+	clock   dblock.Clock
+}
+
+func (c *wrappedClient) Obtain(ctx context.Context, key string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
+	l := &listener{backend: c.backend, prefix: c.prefix(key), metrics: c.metrics, clock: c.clock, start: c.clock.Now()}
+	return dblock.ListenObtain(ctx, l, c.Client.Obtain, key, ttl, optionsFns...)
+}
+
+// listener records the events of one lock to the metrics.
+type listener struct {
+	backend, prefix string
+	metrics         Metrics
+	clock           dblock.Clock
+	start           time.Time
+
+	mu         sync.Mutex
+	obtainedAt time.Time
+}
+
+func (l *listener) OnObtain(string, dblock.Lock) {
+	l.mu.Lock()
+	l.obtainedAt = l.clock.Now()
+	l.mu.Unlock()
+	l.metrics.ObserveObtain(l.backend, l.prefix, l.clock.Now().Sub(l.start), nil)
+}
+
+func (l *listener) OnObtainFailed(_ string, err error) {
+	l.metrics.ObserveObtain(l.backend, l.prefix, l.clock.Now().Sub(l.start), err)
+}
+
+func (l *listener) OnRetry(string, int, time.Duration) {
+	l.metrics.ObserveRetry(l.backend, l.prefix)
+}
+
+func (l *listener) OnRefresh(string, time.Duration) {
+	l.metrics.ObserveRefresh(l.backend, l.prefix, nil)
+}
+
+func (l *listener) OnRefreshFailed(_ string, err error) {
+	l.metrics.ObserveRefresh(l.backend, l.prefix, err)
+}
+
+func (l *listener) OnRelease(string) { l.release(false) }
+func (l *listener) OnExpired(string) { l.release(true) }
+
+func (l *listener) release(expired bool) {
+	l.mu.Lock()
+	hold := l.clock.Now().Sub(l.obtainedAt)
+	l.mu.Unlock()
+	l.metrics.ObserveRelease(l.backend, l.prefix, hold, expired)
+}
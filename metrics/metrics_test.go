@@ -0,0 +1,81 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+	"github.com/bingoohuang/dblock/clocktest"
+	"github.com/bingoohuang/dblock/dblocktest"
+	"github.com/bingoohuang/dblock/metrics"
+)
+
+func TestWrap(t *testing.T) {
+	ctx := context.Background()
+	clock := clocktest.NewFakeClock(time.Now())
+	prom := metrics.NewPrometheus(0.01, 1)
+	client := metrics.Wrap(dblocktest.NewClient(dblock.WithClock(clock)), "mem", prom, metrics.WithClock(clock))
+
+	lock, err := client.Obtain(ctx, "job:1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contended := make(chan error, 1)
+	go func() {
+		_, err := client.Obtain(ctx, "job:1", time.Minute, dblock.WithRetryStrategy(dblock.LimitRetry(dblock.LinearBackoff(time.Millisecond), 2)))
+		contended <- err
+	}()
+	for i := 0; i < 2; i++ {
+		// the lifetime of the lock, the deadline and the backoff of the contender
+		clock.WaitTimers(3)
+		clock.Advance(time.Millisecond)
+	}
+	if err := <-contended; !errors.Is(err, dblock.ErrNotObtained) {
+		t.Fatalf("expected %v, got %v", dblock.ErrNotObtained, err)
+	}
+
+	if err := lock.Refresh(ctx, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(2 * time.Second)
+	if err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var sb strings.Builder
+	if _, err := prom.WriteTo(&sb); err != nil {
+		t.Fatal(err)
+	}
+	out := sb.String()
+	for _, line := range []string{
+		"# TYPE dblock_obtain_total counter",
+		`dblock_obtain_total{backend="mem",prefix="job",result="ok"} 1`,
+		`dblock_obtain_total{backend="mem",prefix="job",result="not_obtained"} 1`,
+		`dblock_retry_total{backend="mem",prefix="job"} 2`,
+		`dblock_refresh_total{backend="mem",prefix="job",result="ok"} 1`,
+		`dblock_release_total{backend="mem",prefix="job",result="released"} 1`,
+		"# TYPE dblock_wait_seconds histogram",
+		`dblock_wait_seconds_bucket{backend="mem",prefix="job",le="1"} 2`,
+		`dblock_wait_seconds_bucket{backend="mem",prefix="job",le="+Inf"} 2`,
+		`dblock_wait_seconds_count{backend="mem",prefix="job"} 2`,
+		`dblock_hold_seconds_bucket{backend="mem",prefix="job",le="1"} 0`,
+		`dblock_hold_seconds_bucket{backend="mem",prefix="job",le="+Inf"} 1`,
+		`dblock_hold_seconds_count{backend="mem",prefix="job"} 1`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("expected %q in:\n%s", line, out)
+		}
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	for key, exp := range map[string]string{"job:1": "job", "job": "job", "a:b:c": "a", "": ""} {
+		if got := metrics.Prefix(key); exp != got {
+			t.Errorf("expected %q, got %q", exp, got)
+		}
+	}
+}
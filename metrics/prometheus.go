@@ -0,0 +1,248 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+)
+
+// DefaultBuckets are the upper bounds in seconds of the histogram buckets, the same as the Prometheus client.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Prometheus keeps the metrics in memory, and exposes them in the Prometheus text exposition format:
+//
+//	dblock_obtain_total{backend, prefix, result="ok|not_obtained|timeout|error"}
+//	dblock_retry_total{backend, prefix}
+//	dblock_refresh_total{backend, prefix, result="ok|error"}
+//	dblock_release_total{backend, prefix, result="released|expired"}
+//	dblock_wait_seconds{backend, prefix} histogram of the time waited to obtain the locks
+//	dblock_hold_seconds{backend, prefix} histogram of the time the locks held
+type Prometheus struct {
+	buckets []float64
+
+	mu         sync.Mutex
+	counters   map[string]map[labels]uint64
+	histograms map[string]map[labels]*histogram
+}
+
+var _ Metrics = (*Prometheus)(nil)
+
+// NewPrometheus creates a new Prometheus with the histogram buckets, DefaultBuckets is used if empty.
+func NewPrometheus(buckets ...float64) *Prometheus {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	return &Prometheus{
+		buckets:    buckets,
+		counters:   map[string]map[labels]uint64{},
+		histograms: map[string]map[labels]*histogram{},
+	}
+}
+
+type labels struct {
+	backend, prefix, result string
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+const (
+	obtainTotal  = "dblock_obtain_total"
+	retryTotal   = "dblock_retry_total"
+	refreshTotal = "dblock_refresh_total"
+	releaseTotal = "dblock_release_total"
+	waitSeconds  = "dblock_wait_seconds"
+	holdSeconds  = "dblock_hold_seconds"
+)
+
+var help = map[string]string{
+	obtainTotal:  "Total number of obtaining the locks.",
+	retryTotal:   "Total number of retries of obtaining the contended locks.",
+	refreshTotal: "Total number of refreshing the locks.",
+	releaseTotal: "Total number of the locks released, or expired before released.",
+	waitSeconds:  "Time waited to obtain the locks in seconds.",
+	holdSeconds:  "Time the locks held in seconds.",
+}
+
+func (p *Prometheus) inc(name string, l labels) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	m, ok := p.counters[name]
+	if !ok {
+		m = map[labels]uint64{}
+		p.counters[name] = m
+	}
+	m[l]++
+}
+
+func (p *Prometheus) observe(name string, l labels, d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	m, ok := p.histograms[name]
+	if !ok {
+		m = map[labels]*histogram{}
+		p.histograms[name] = m
+	}
+	h, ok := m[l]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(p.buckets))}
+		m[l] = h
+	}
+
+	v := d.Seconds()
+	for i, le := range p.buckets {
+		if v <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (p *Prometheus) ObserveObtain(backend, prefix string, wait time.Duration, err error) {
+	result := "ok"
+	switch {
+	case err == nil:
+	case errors.Is(err, dblock.ErrNotObtained):
+		result = "not_obtained"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		result = "timeout"
+	default:
+		result = "error"
+	}
+	p.inc(obtainTotal, labels{backend: backend, prefix: prefix, result: result})
+	p.observe(waitSeconds, labels{backend: backend, prefix: prefix}, wait)
+}
+
+func (p *Prometheus) ObserveRetry(backend, prefix string) {
+	p.inc(retryTotal, labels{backend: backend, prefix: prefix})
+}
+
+func (p *Prometheus) ObserveRefresh(backend, prefix string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	p.inc(refreshTotal, labels{backend: backend, prefix: prefix, result: result})
+}
+
+func (p *Prometheus) ObserveRelease(backend, prefix string, hold time.Duration, expired bool) {
+	result := "released"
+	if expired {
+		result = "expired"
+	}
+	p.inc(releaseTotal, labels{backend: backend, prefix: prefix, result: result})
+	p.observe(holdSeconds, labels{backend: backend, prefix: prefix}, hold)
+}
+
+// WriteTo writes the metrics in the Prometheus text exposition format.
+func (p *Prometheus) WriteTo(w io.Writer) (int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, name := range []string{obtainTotal, retryTotal, refreshTotal, releaseTotal} {
+		m := p.counters[name]
+		if len(m) == 0 {
+			continue
+		}
+		writeHeader(bw, name, "counter")
+		for _, l := range sortedLabels(m) {
+			bw.WriteString(name + l.format("") + " " + strconv.FormatUint(m[l], 10) + "\n")
+		}
+	}
+	for _, name := range []string{waitSeconds, holdSeconds} {
+		m := p.histograms[name]
+		if len(m) == 0 {
+			continue
+		}
+		writeHeader(bw, name, "histogram")
+		for _, l := range sortedLabels(m) {
+			h := m[l]
+			for i, le := range p.buckets {
+				bw.WriteString(name + "_bucket" + l.format(formatFloat(le)) + " " + strconv.FormatUint(h.counts[i], 10) + "\n")
+			}
+			bw.WriteString(name + "_bucket" + l.format("+Inf") + " " + strconv.FormatUint(h.count, 10) + "\n")
+			bw.WriteString(name + "_sum" + l.format("") + " " + formatFloat(h.sum) + "\n")
+			bw.WriteString(name + "_count" + l.format("") + " " + strconv.FormatUint(h.count, 10) + "\n")
+		}
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP serves the metrics, e.g. on /metrics to be scraped by Prometheus.
+func (p *Prometheus) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = p.WriteTo(w)
+}
+
+func writeHeader(w *bufio.Writer, name, typ string) {
+	w.WriteString("# HELP " + name + " " + help[name] + "\n")
+	w.WriteString("# TYPE " + name + " " + typ + "\n")
+}
+
+func sortedLabels[V any](m map[labels]V) []labels {
+	ls := make([]labels, 0, len(m))
+	for l := range m {
+		ls = append(ls, l)
+	}
+	sort.Slice(ls, func(i, j int) bool {
+		a, b := ls[i], ls[j]
+		if a.backend != b.backend {
+			return a.backend < b.backend
+		}
+		if a.prefix != b.prefix {
+			return a.prefix < b.prefix
+		}
+		return a.result < b.result
+	})
+	return ls
+}
+
+// format formats the labels like {backend="redis",prefix="job",result="ok"}, with the le label of the bucket if not empty.
+func (l labels) format(le string) string {
+	s := `{backend="` + escapeLabel(l.backend) + `",prefix="` + escapeLabel(l.prefix) + `"`
+	if l.result != "" {
+		s += `,result="` + escapeLabel(l.result) + `"`
+	}
+	if le != "" {
+		s += `,le="` + le + `"`
+	}
+	return s + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+func formatFloat(f float64) string { return strconv.FormatFloat(f, 'g', -1, 64) }
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -15,7 +15,7 @@ import (
 	"github.com/xo/dburl"
 )
 
-func Create(uri string) (dblock.ClientCloser, error) {
+func Create(uri string, optionsFns ...dblock.ClientOptionsFn) (dblock.ClientCloser, error) {
 	v, err := url.Parse(uri)
 	if err != nil {
 		return nil, fmt.Errorf("parse url: %w", err)
@@ -38,12 +38,16 @@ func Create(uri string) (dblock.ClientCloser, error) {
 			return nil, err
 		}
 		return &redisClientCloser{
-			Client:      redislock.New(redisClient),
+			Client:      redislock.New(redisClient, optionsFns...),
 			redisClient: redisClient,
 		}, nil
 	}
 
-	db, err := dburl.Open(uri)
+	u, err := dburl.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("dburl parse: %w", err)
+	}
+	db, err := sql.Open(u.Driver, u.DSN)
 	if err != nil {
 		return nil, fmt.Errorf("dburl open: %w", err)
 	}
@@ -52,14 +56,20 @@ func Create(uri string) (dblock.ClientCloser, error) {
 		return nil, err
 	}
 
+	client := rdblock.New(db, optionsFns...)
+	// the drivers of the postgres://, pg://, pgx:// and the compatible schemes
+	if u.Driver == "postgres" || u.Driver == "pgx" {
+		client.Dialect = rdblock.PostgreSQL
+	}
 	return &dbClientCloser{
 		DB:     db,
-		Client: rdblock.New(db),
+		Client: client,
 	}, nil
 }
 
+// redisClientCloser embeds the concrete client, to keep its capabilities like dblock.Lister.
 type redisClientCloser struct {
-	dblock.Client
+	*redislock.Client
 	redisClient *redis.Client
 }
 
@@ -67,8 +77,9 @@ func (r redisClientCloser) Close() error {
 	return r.redisClient.Close()
 }
 
+// dbClientCloser embeds the concrete client, to keep its capabilities like dblock.Lister.
 type dbClientCloser struct {
-	dblock.Client
+	*rdblock.Client
 	DB *sql.DB
 }
 
@@ -0,0 +1,107 @@
+package rdblock
+
+import (
+	"context"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+)
+
+// ForceRelease releases the lock of the key whoever holds it,
+// the row is kept with the operator and the reason recorded in meta_value.
+// May return ErrLockNotHeld if the lock is not held.
+func (c *Client) ForceRelease(ctx context.Context, key, reason string) error {
+	c.prepare(ctx)
+
+	// a new token makes the refreshing of the former holder fail
+	token, err := dblock.RandomToken()
+	if err != nil {
+		return err
+	}
+
+	sh := &shedLock{
+		Table: c.Table,
+		Name:  key,
+		Token: token,
+		Meta:  dblock.AdminRecord(c.options.Clock, "force released", reason),
+		Until: c.now().Add(-time.Second).Format(time.RFC3339Nano),
+		clock: c.options.Clock,
+	}
+	ok, err := execAffected(ctx, c.client, sh.replace(`UPDATE {Table} SET lock_until = {Until}, locked_at = {Now}, `+
+		`locked_by = {By}, token_value = {Token}, meta_value = {Meta}, locked_pid = {LockedPid}, hold_count = 1 `+
+		`WHERE lock_name = {Name} AND lock_until > {Now}`))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return dblock.ErrLockNotHeld
+	}
+
+	c.notify(ctx, c.client, key)
+	return nil
+}
+
+// Steal takes over the lock of the key with the given TTL whoever holds it,
+// the operator and the reason are recorded in meta_value instead of the metadata option.
+func (c *Client) Steal(ctx context.Context, key string, ttl time.Duration, reason string, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
+	opt, err := c.options.ParseOptions(optionsFns...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.prepare(ctx)
+
+	lockUntil := opt.Clock.Now().Add(ttl)
+	sh := &shedLock{
+		Table: c.Table,
+		Name:  key,
+		Token: opt.Token,
+		Meta:  dblock.AdminRecord(opt.Clock, "stolen", reason),
+		Until: lockUntil.Format(time.RFC3339Nano),
+		clock: c.options.Clock,
+	}
+	ok, err := execAffected(ctx, c.client, sh.replace(`UPDATE {Table} SET lock_until = {Until}, locked_at = {Now}, `+
+		`locked_by = {By}, token_value = {Token}, meta_value = {Meta}, locked_pid = {LockedPid}, `+
+		`fence_value = fence_value + 1, hold_count = 1 WHERE lock_name = {Name}`))
+	if err != nil {
+		return nil, err
+	}
+	// inserted by others at the same time if failed
+	if !ok && !sh.insert(ctx, c.client) {
+		return nil, dblock.ErrNotObtained
+	}
+	if found, err := sh.query(ctx, c.client); err != nil {
+		return nil, err
+	} else if !found {
+		return nil, dblock.ErrNotObtained
+	}
+
+	lock := &Lock{
+		Client:   c,
+		Key:      key,
+		table:    c.Table,
+		token:    opt.Token,
+		metadata: sh.Meta,
+		Until:    sh.Until,
+		fence:    sh.Fence,
+		holds:    sh.Holds,
+		lifetime: dblock.NewLifetime(opt.Clock, lockUntil),
+	}
+	if opt.AutoRefresh > 0 {
+		lock.refresher = dblock.StartRefresher(opt.Clock, lock, ttl, opt.AutoRefresh, opt.RefreshFailed)
+	}
+	return lock, nil
+}
+
+func (l *shedLock) replace(s string) stmt {
+	return bind(s, map[string]any{
+		"Table":     ident(l.Table),
+		"Name":      l.Name,
+		"Until":     l.Until,
+		"Now":       now(l.clock).Format(time.RFC3339Nano),
+		"By":        Hostname,
+		"Token":     l.Token,
+		"Meta":      metaArg(l.Meta),
+		"LockedPid": Pid,
+	})
+}
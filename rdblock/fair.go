@@ -0,0 +1,129 @@
+package rdblock
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+)
+
+// obtainFair obtains the lock when the waiter is the head of the queue in the WaiterTable,
+// or returns nil lock with the position of the waiter. The waiter joins the queue and obtains the lock
+// in a single transaction, the DB should implement TxDB.
+func (c *Client) obtainFair(ctx context.Context, key, token, meta, lockUntil string, waitUntil time.Time, reentrant bool) (*shedLock, int, error) {
+	var sh *shedLock
+	var position int
+	w := &waiterRow{Table: c.WaiterTable, Name: key, Token: token, WaitUntil: waitUntil.UnixNano(), clock: c.options.Clock}
+	if err := w.prune(ctx, c.client); err != nil {
+		return nil, 0, err
+	}
+
+	// committed even if not obtained, to keep the waiter in the queue
+	_, err := c.inTx(ctx, func(db DB) (bool, error) {
+		if reentrant {
+			sh = &shedLock{Table: c.Table, Name: key, Token: token, Meta: meta, Until: lockUntil, clock: c.options.Clock}
+			if ok, err := sh.reenter(ctx, db); err != nil {
+				return false, err
+			} else if ok {
+				found, err := sh.query(ctx, db)
+				if !found {
+					sh = nil
+				}
+				return err == nil, err
+			}
+			sh = nil
+		}
+
+		var err error
+		if position, err = w.join(ctx, db); err != nil || position > 0 {
+			return err == nil, err
+		}
+
+		var ok bool
+		if sh, ok, err = c.obtain(ctx, db, c.Table, key, token, meta, lockUntil, false); err != nil || !ok {
+			return err == nil, err
+		}
+
+		_, err = w.leave(ctx, db)
+		return err == nil, err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return sh, position, nil
+}
+
+// waiterRow is a row of the waiters queued for a fair lock, the waiters are ordered by queued_at,
+// a sequence increasing in the queue of the lock, the waiting deadlines are kept in unix nanoseconds.
+type waiterRow struct {
+	Table     string
+	Name      string
+	Token     string
+	QueuedAt  int64
+	WaitUntil int64
+
+	// clock tells the time in the statements.
+	clock dblock.Clock
+}
+
+func (l *waiterRow) replace(s string) stmt {
+	return bind(s, map[string]any{
+		"Table":     ident(l.Table),
+		"Name":      l.Name,
+		"Token":     l.Token,
+		"QueuedAt":  l.QueuedAt,
+		"WaitUntil": l.WaitUntil,
+		"Now":       now(l.clock).UnixNano(),
+	})
+}
+
+// prune drops the waiters which stopped waiting, out of the transaction of join not to lock the whole queue.
+func (l *waiterRow) prune(ctx context.Context, db DB) error {
+	s := l.replace(`DELETE FROM {Table} WHERE lock_name = {Name} AND wait_until <= {Now}`)
+	if _, err := db.ExecContext(ctx, s.query, s.args...); err != nil {
+		return fmt.Errorf("delete waiter %q : %w", s.query, err)
+	}
+	return nil
+}
+
+// join adds the waiter to the tail of the queue, or keeps it waiting, and returns its position.
+func (l *waiterRow) join(ctx context.Context, db DB) (int, error) {
+	ok, err := execAffected(ctx, db, l.replace(`UPDATE {Table} SET wait_until = {WaitUntil} `+
+		`WHERE lock_name = {Name} AND token_value = {Token}`))
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		// the next sequence of the queue, which does not depend on the clocks of the waiters,
+		// the waiters joining at once get the same one, and are ordered by their tokens.
+		s := l.replace(`SELECT COALESCE(MAX(queued_at), 0) + 1 FROM {Table} WHERE lock_name = {Name}`)
+		if err := db.QueryRowContext(ctx, s.query, s.args...).Scan(&l.QueuedAt); err != nil {
+			return 0, fmt.Errorf("query: %w", err)
+		}
+
+		s = l.replace(`INSERT INTO {Table} (lock_name, token_value, queued_at, wait_until) ` +
+			`VALUES({Name}, {Token}, {QueuedAt}, {WaitUntil})`)
+		if _, err := db.ExecContext(ctx, s.query, s.args...); err != nil {
+			return 0, fmt.Errorf("insert waiter %q : %w", s.query, err)
+		}
+	}
+
+	return l.position(ctx, db)
+}
+
+// position returns the number of the waiters ahead.
+func (l *waiterRow) position(ctx context.Context, db DB) (int, error) {
+	s := l.replace(`SELECT COUNT(*) FROM {Table} w, {Table} m ` +
+		`WHERE m.lock_name = {Name} AND m.token_value = {Token} AND w.lock_name = {Name} ` +
+		`AND (w.queued_at < m.queued_at OR (w.queued_at = m.queued_at AND w.token_value < m.token_value))`)
+	var n int
+	if err := db.QueryRowContext(ctx, s.query, s.args...).Scan(&n); err != nil {
+		return 0, fmt.Errorf("query: %w", err)
+	}
+	return n, nil
+}
+
+func (l *waiterRow) leave(ctx context.Context, db DB) (bool, error) {
+	return execAffected(ctx, db, l.replace(`DELETE FROM {Table} WHERE lock_name = {Name} AND token_value = {Token}`))
+}
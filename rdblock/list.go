@@ -0,0 +1,66 @@
+package rdblock
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+)
+
+// List returns the views of the held locks whose names start with the prefix.
+// The DB should implement QueryDB.
+func (c *Client) List(ctx context.Context, prefix string) ([]dblock.LockView, error) {
+	return c.list(ctx, prefix, false)
+}
+
+// ListAll is like List, but includes the expired locks whose rows are kept in the table.
+func (c *Client) ListAll(ctx context.Context, prefix string) ([]dblock.LockView, error) {
+	return c.list(ctx, prefix, true)
+}
+
+func (c *Client) list(ctx context.Context, prefix string, expired bool) ([]dblock.LockView, error) {
+	db, ok := c.client.(QueryDB)
+	if !ok {
+		return nil, ErrQueryNotSupported
+	}
+
+	table := c.getTable()
+	s := `select lock_name, lock_until, locked_at, locked_by, token_value, meta_value, locked_pid, fence_value, hold_count ` +
+		`from {Table} WHERE 1 = 1`
+	if prefix != "" {
+		s += ` AND lock_name LIKE {Prefix} ESCAPE '!'`
+	}
+	if !expired {
+		s += ` AND lock_until > {Now}`
+	}
+	s += ` ORDER BY lock_name`
+	now := c.now()
+	st := bind(s, map[string]any{"Table": ident(table), "Prefix": likePrefix(prefix), "Now": now.Format(time.RFC3339Nano)})
+
+	rows, err := db.QueryContext(ctx, st.query, st.args...)
+	if err != nil {
+		return nil, fmt.Errorf("query %q : %w", st.query, err)
+	}
+	defer rows.Close()
+
+	var views []dblock.LockView
+	for rows.Next() {
+		l := &shedLock{Table: table}
+		if err := rows.Scan(&l.Name, &l.Until, &l.At, &l.By, &l.Token, &l.Meta, &l.Pid, &l.Fence, &l.Holds); err != nil {
+			return nil, fmt.Errorf("scan: %w", err)
+		}
+		views = append(views, l.view(now))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("query: %w", err)
+	}
+	return views, nil
+}
+
+// likePrefix returns the LIKE pattern matching the prefix, escaped by '!'.
+func likePrefix(prefix string) string {
+	r := strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+	return r.Replace(prefix) + "%"
+}
@@ -0,0 +1,191 @@
+package rdblock
+
+import (
+	"context"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+)
+
+// ObtainMulti tries to obtain the locks of all the keys with the given TTL, or none of them,
+// in a single transaction. The DB should implement TxDB.
+// May return ErrNotObtained if not successful.
+func (c *Client) ObtainMulti(ctx context.Context, keys []string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.MultiLock, error) {
+	return c.options.ObtainMulti(ctx, c.obtainMulti, keys, ttl, optionsFns...)
+}
+
+func (c *Client) obtainMulti(ctx context.Context, keys []string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.MultiLock, error) {
+	keys = dblock.UniqueKeys(keys)
+	if len(keys) == 0 {
+		return nil, dblock.ErrNotObtained
+	}
+
+	opt, err := c.options.ParseOptions(optionsFns...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.prepare(ctx)
+
+	var lock *multiLock
+	err = opt.Retry(ctx, ttl, func(ctx context.Context) (bool, error) {
+		lockUntil := opt.Clock.Now().Add(ttl)
+		lockUntilStr := lockUntil.Format(time.RFC3339Nano)
+		ok, err := c.inTx(ctx, func(db DB) (bool, error) {
+			for _, key := range keys {
+				sh := &shedLock{Table: c.Table, Name: key, Token: opt.Token, Meta: opt.Meta, Until: lockUntilStr, clock: c.options.Clock}
+				// update first, a failed insert aborts the transaction in some databases, e.g. PostgreSQL.
+				if ok, err := sh.update(ctx, db); err != nil {
+					return false, err
+				} else if !ok && !sh.insert(ctx, db) {
+					return false, nil
+				}
+			}
+			return true, nil
+		})
+		if err != nil || !ok {
+			return false, err
+		}
+
+		lock = &multiLock{
+			Client:   c,
+			keys:     keys,
+			token:    opt.Token,
+			metadata: opt.Meta,
+			lifetime: dblock.NewLifetime(opt.Clock, lockUntil),
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if opt.AutoRefresh > 0 {
+		lock.refresher = dblock.StartRefresher(opt.Clock, lock, ttl, opt.AutoRefresh, opt.RefreshFailed)
+	}
+	return lock, nil
+}
+
+// inTx runs fn in a transaction, which is committed only when fn returns true.
+func (c *Client) inTx(ctx context.Context, fn func(db DB) (bool, error)) (bool, error) {
+	txDB, ok := c.client.(TxDB)
+	if !ok {
+		return false, ErrTxNotSupported
+	}
+
+	tx, err := txDB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+
+	if ok, err := fn(txDb{c.wrapDB(tx)}); err != nil || !ok {
+		_ = tx.Rollback()
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// txDb is the DB of a transaction run by inTx.
+type txDb struct{ DB }
+
+// multiLock represents the obtained locks of multiple keys.
+type multiLock struct {
+	*Client
+	keys     []string
+	token    string
+	metadata string
+
+	lifetime  *dblock.Lifetime
+	refresher *dblock.Refresher
+}
+
+// Keys returns the keys of the locks.
+func (l *multiLock) Keys() []string { return l.keys }
+
+// Token returns the token value set by the locks.
+func (l *multiLock) Token() string { return l.token }
+
+// Metadata returns the metadata of the locks.
+func (l *multiLock) Metadata() string { return l.metadata }
+
+// Done returns a channel that's closed when any lock is lost.
+func (l *multiLock) Done() <-chan struct{} { return l.lifetime.Done() }
+
+// Context returns a copy of parent which is cancelled when any lock is lost.
+func (l *multiLock) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	return l.lifetime.Context(parent)
+}
+
+// TTL returns the minimum remaining time-to-live. Returns 0 if any lock has expired.
+func (l *multiLock) TTL(ctx context.Context) (time.Duration, error) {
+	var min time.Duration
+	for i, key := range l.keys {
+		lock := &Lock{Client: l.Client, Key: key, table: l.Table, token: l.token, lifetime: l.lifetime}
+		ttl, err := lock.TTL(ctx)
+		if err != nil || ttl == 0 {
+			return 0, err
+		}
+		if i == 0 || ttl < min {
+			min = ttl
+		}
+	}
+
+	return min, nil
+}
+
+// Refresh extends all the locks with a new TTL in a single transaction.
+// May return ErrNotObtained if refresh is unsuccessful.
+func (l *multiLock) Refresh(ctx context.Context, ttl time.Duration) error {
+	until := l.now().Add(ttl)
+	ok, err := l.inTx(ctx, func(db DB) (bool, error) {
+		for _, key := range l.keys {
+			sh := &shedLock{Table: l.Table, Name: key, Token: l.token, Until: until.Format(time.RFC3339Nano), clock: l.options.Clock}
+			if ok, err := sh.extend(ctx, db); err != nil || !ok {
+				return false, err
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return err
+	}
+	if ok {
+		l.lifetime.Extend(until)
+		return nil
+	}
+	l.lifetime.Lose()
+	return dblock.ErrNotObtained
+}
+
+// Release manually releases all the locks in a single transaction.
+// May return ErrLockNotHeld if any lock is not held.
+func (l *multiLock) Release(ctx context.Context) error {
+	if l.refresher != nil {
+		l.refresher.Stop()
+	}
+	defer l.lifetime.Lose()
+
+	released := 0
+	if _, err := l.inTx(ctx, func(db DB) (bool, error) {
+		for _, key := range l.keys {
+			sh := &shedLock{Table: l.Table, Name: key, Token: l.token, clock: l.options.Clock}
+			ok, err := sh.unlock(ctx, db)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				released++
+				l.notify(ctx, db, key)
+			}
+		}
+		return true, nil
+	}); err != nil {
+		return err
+	}
+
+	if released < len(l.keys) {
+		return dblock.ErrLockNotHeld
+	}
+	return nil
+}
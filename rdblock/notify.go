@@ -0,0 +1,49 @@
+package rdblock
+
+import (
+	"context"
+	"hash/fnv"
+	"strconv"
+)
+
+// WakeSource listens to the notifications of the released locks, like PostgreSQL LISTEN.
+// Listen is called by every waiting Obtain, the implementation should share one connection
+// among the channels and the waiters, rather than open a connection per call.
+type WakeSource interface {
+	// Listen returns a channel which receives every time the channel is notified, until ctx is done.
+	Listen(ctx context.Context, channel string) (<-chan struct{}, error)
+}
+
+// Channel returns the notification channel derived from the lock name,
+// which is short enough to be a PostgreSQL identifier.
+func (c *Client) Channel(key string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return c.getTable() + "_" + strconv.FormatUint(h.Sum64(), 16)
+}
+
+// notify sends the notification of the released lock, the errors are ignored
+// since the waiters fall back to polling.
+func (c *Client) notify(ctx context.Context, db DB, key string) {
+	if !c.Notify {
+		return
+	}
+
+	if _, err := db.ExecContext(ctx, `SELECT pg_notify(?, 'released')`, c.Channel(key)); err != nil {
+		c.debug(ctx, "dblock: notify failed", "key", key, "error", err)
+	}
+}
+
+// released listens to the notifications of the released lock, returns nil if not available.
+func (c *Client) released(ctx context.Context, key string) <-chan struct{} {
+	if c.WakeSource == nil {
+		return nil
+	}
+
+	ch, err := c.WakeSource.Listen(ctx, c.Channel(key))
+	if err != nil {
+		c.debug(ctx, "dblock: listen failed", "key", key, "channel", c.Channel(key), "error", err)
+		return nil
+	}
+	return ch
+}
@@ -0,0 +1,76 @@
+package rdblock
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+)
+
+// AcquirePermit tries to acquire one of the limit permits of the key with the given TTL.
+// The permits are kept as locks in the slots named key#0 to key#(limit-1) of the PermitTable,
+// the expired ones are reclaimed automatically.
+// May return ErrNotObtained if not successful.
+func (c *Client) AcquirePermit(ctx context.Context, key string, limit int, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
+	acquire := func(ctx context.Context, key string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
+		return c.acquirePermit(ctx, key, limit, ttl, optionsFns...)
+	}
+	return c.options.Obtain(ctx, acquire, key, ttl, optionsFns...)
+}
+
+func (c *Client) acquirePermit(ctx context.Context, key string, limit int, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
+	if limit < 1 {
+		return nil, dblock.ErrNotObtained
+	}
+
+	opt, err := c.options.ParseOptions(optionsFns...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.prepare(ctx)
+
+	var lock *Lock
+	err = opt.Retry(ctx, ttl, func(ctx context.Context) (bool, error) {
+		lockUntil := opt.Clock.Now().Add(ttl)
+		lockUntilStr := lockUntil.Format(time.RFC3339Nano)
+
+		// start from a random slot to spread the contention
+		offset := rand.Intn(limit)
+		for i := 0; i < limit; i++ {
+			slot := key + "#" + strconv.Itoa((offset+i)%limit)
+			sh, ok, err := c.obtain(ctx, c.client, c.PermitTable, slot, opt.Token, opt.Meta, lockUntilStr, false)
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				continue
+			}
+
+			lock = &Lock{
+				Client:   c,
+				Key:      slot,
+				table:    c.PermitTable,
+				token:    opt.Token,
+				metadata: sh.Meta,
+				Until:    lockUntilStr,
+				fence:    sh.Fence,
+				holds:    sh.Holds,
+				lifetime: dblock.NewLifetime(opt.Clock, lockUntil),
+			}
+			return true, nil
+		}
+
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if opt.AutoRefresh > 0 {
+		lock.refresher = dblock.StartRefresher(opt.Clock, lock, ttl, opt.AutoRefresh, opt.RefreshFailed)
+	}
+	return lock, nil
+}
@@ -3,10 +3,12 @@ package rdblock
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"reflect"
 	"strconv"
 	"strings"
 	"time"
@@ -19,47 +21,210 @@ type DB interface {
 	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
 }
 
-type logDb struct {
-	db DB
+// TxDB is the DB which supports transactions, like *sql.DB.
+type TxDB interface {
+	DB
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
 }
 
-func (d *logDb) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
-	log.Printf("query: %q", query)
+// ErrTxNotSupported is returned when the DB does not support transactions.
+var ErrTxNotSupported = errors.New("rdblock: transaction not supported")
+
+// QueryDB is the DB which supports querying multiple rows, like *sql.DB.
+type QueryDB interface {
+	DB
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// ErrQueryNotSupported is returned when the DB does not support querying multiple rows.
+var ErrQueryNotSupported = errors.New("rdblock: query not supported")
+
+// clientDb rebinds the bind variables of the statements to the dialect of the client,
+// and logs the statements at the debug level if the logger is set.
+type clientDb struct {
+	db     DB
+	client *Client
+}
+
+func (d *clientDb) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	if db, ok := d.db.(TxDB); ok {
+		return db.BeginTx(ctx, opts)
+	}
+
+	return nil, ErrTxNotSupported
+}
+
+// log logs the statement with its bind values, the metadata is redacted if set by dblock.WithRedactMetadata.
+func (d *clientDb) log(ctx context.Context, query string, args []any, attrs ...any) {
+	options := d.client.options
+	if options.Logger == nil || !options.Logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+
+	values := make([]any, len(args))
+	for i, arg := range args {
+		if meta, ok := arg.(metaArg); ok {
+			arg = options.LogMetadata(string(meta))
+		}
+		values[i] = arg
+	}
+	options.Logger.DebugContext(ctx, "dblock: sql", append([]any{"query", query, "args", values}, attrs...)...)
+}
+
+func (d *clientDb) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	query = d.client.Dialect.rebind(query)
+	d.log(ctx, query, args)
+	if db, ok := d.db.(QueryDB); ok {
+		return db.QueryContext(ctx, query, args...)
+	}
+
+	return nil, ErrQueryNotSupported
+}
+
+func (d *clientDb) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	query = d.client.Dialect.rebind(query)
+	d.log(ctx, query, args)
 	return d.db.QueryRowContext(ctx, query, args...)
 }
 
-func (d *logDb) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
-	log.Printf("query: %q", query)
+func (d *clientDb) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	query = d.client.Dialect.rebind(query)
+	start := time.Now()
 	result, err := d.db.ExecContext(ctx, query, args...)
-	if result != nil {
-		if affected, err := result.RowsAffected(); err == nil {
-			log.Printf("affected: %d", affected)
-		}
+	if err != nil {
+		d.log(ctx, query, args, "duration", time.Since(start), "error", err)
+	} else if affected, err := result.RowsAffected(); err == nil {
+		d.log(ctx, query, args, "duration", time.Since(start), "affected", affected)
+	} else {
+		d.log(ctx, query, args, "duration", time.Since(start))
 	}
 
 	return result, err
 }
 
+// Debug logs the SQL statements to stderr at the debug level, if no logger set by dblock.WithLogger.
+//
+// Deprecated: use dblock.WithLogger with a debug level handler instead.
 var Debug bool
 
 // Client wraps a redis client.
 type Client struct {
 	client             DB
 	Table              string
+	SharedTable        string
+	PermitTable        string
+	WaiterTable        string
 	NotAutoCreateTable bool
 
+	// Notify sends a NOTIFY on the channel derived from the lock name when the lock is released, PostgreSQL only.
+	Notify bool
+	// WakeSource, if not nil, wakes the waiters in Obtain on the notifications, the polling is kept as the fallback.
+	WakeSource WakeSource
+	// Dialect is the SQL dialect of the DB, detected by New from the driver of a *sql.DB, MySQL otherwise.
+	Dialect Dialect
+
+	options                dblock.ClientOptions
 	autoCreateTableChecked bool
 }
 
 // New creates a new Client instance with a custom namespace.
-func New(client DB) *Client {
-	c := &Client{client: client}
-	if Debug {
-		c.client = &logDb{db: client}
+func New(client DB, optionsFns ...dblock.ClientOptionsFn) *Client {
+	c := &Client{client: client, Dialect: detectDialect(client), options: dblock.ParseClientOptions(optionsFns...)}
+	if c.options.Logger == nil && Debug {
+		c.options.Logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
 	}
+	c.client = c.wrapDB(client)
 	return c
 }
 
+// wrapDB wraps the db to rebind and log the SQL statements.
+func (c *Client) wrapDB(db DB) DB {
+	return &clientDb{db: db, client: c}
+}
+
+// debug logs at the debug level if the logger is set.
+func (c *Client) debug(ctx context.Context, msg string, args ...any) {
+	if c.options.Logger != nil {
+		c.options.Logger.DebugContext(ctx, msg, args...)
+	}
+}
+
+func (c *Client) autoCreateTable(ctx context.Context) {
+	if c.NotAutoCreateTable || c.autoCreateTableChecked {
+		return
+	}
+
+	var ss []string
+	// the permits are kept in slots like the locks
+	for _, table := range []string{c.Table, c.PermitTable} {
+		ss = append(ss,
+			`CREATE TABLE `+table+`(lock_name VARCHAR(64) NOT NULL PRIMARY KEY, `+
+				`lock_until VARCHAR(64) NOT NULL, locked_at VARCHAR(64) NOT NULL, locked_by VARCHAR(1024) NOT NULL, `+
+				`token_value VARCHAR(64) NOT NULL, meta_value TEXT NOT NULL, locked_pid VARCHAR(64) NOT NULL, `+
+				`fence_value BIGINT NOT NULL DEFAULT 0, hold_count BIGINT NOT NULL DEFAULT 1)`)
+	}
+	ss = append(ss, `CREATE TABLE `+c.SharedTable+`(lock_name VARCHAR(64) NOT NULL, token_value VARCHAR(64) NOT NULL, `+
+		`lock_until VARCHAR(64) NOT NULL, locked_at VARCHAR(64) NOT NULL, locked_by VARCHAR(1024) NOT NULL, `+
+		`meta_value TEXT NOT NULL, locked_pid VARCHAR(64) NOT NULL, PRIMARY KEY (lock_name, token_value))`)
+	ss = append(ss, `CREATE TABLE `+c.WaiterTable+`(lock_name VARCHAR(64) NOT NULL, token_value VARCHAR(64) NOT NULL, `+
+		`queued_at BIGINT NOT NULL, wait_until BIGINT NOT NULL, PRIMARY KEY (lock_name, token_value))`)
+
+	for _, s := range ss {
+		if _, err := c.client.ExecContext(ctx, s); err != nil {
+			c.debug(ctx, "dblock: auto create table failed", "error", err)
+		}
+	}
+
+	upgrades, err := c.upgrades(ctx)
+	if err != nil {
+		c.debug(ctx, "dblock: check the columns to upgrade failed", "error", err)
+	}
+	for _, s := range upgrades {
+		if _, err := c.client.ExecContext(ctx, s); err != nil {
+			c.debug(ctx, "dblock: upgrade table failed", "error", err)
+		}
+	}
+	c.autoCreateTableChecked = true
+}
+
+// upgrades returns the statements to upgrade the tables created by the earlier versions,
+// only for the columns missing or of the old types in information_schema.
+func (c *Client) upgrades(ctx context.Context) ([]string, error) {
+	var ss []string
+	// the tables created before fencing and reentrant locks
+	for _, table := range []string{c.Table, c.PermitTable} {
+		for _, column := range []string{"fence_value BIGINT NOT NULL DEFAULT 0", "hold_count BIGINT NOT NULL DEFAULT 1"} {
+			name, _, _ := strings.Cut(column, " ")
+			if typ, err := c.columnType(ctx, table, name); err != nil {
+				return nil, err
+			} else if typ == "" {
+				ss = append(ss, `ALTER TABLE `+table+` ADD `+column)
+			}
+		}
+	}
+	// the tables created with meta_value VARCHAR(1024) before the JSON metadata
+	for _, table := range []string{c.Table, c.PermitTable, c.SharedTable} {
+		if typ, err := c.columnType(ctx, table, "meta_value"); err != nil {
+			return nil, err
+		} else if typ != "" && !strings.EqualFold(typ, "text") {
+			ss = append(ss, c.Dialect.alterText(table, "meta_value"))
+		}
+	}
+	return ss, nil
+}
+
+// columnType returns the data type of the column in information_schema, empty if the column does not exist.
+func (c *Client) columnType(ctx context.Context, table, column string) (string, error) {
+	var typ string
+	err := c.client.QueryRowContext(ctx, `SELECT data_type FROM information_schema.columns `+
+		`WHERE table_schema = `+c.Dialect.currentSchema()+` AND LOWER(table_name) = LOWER(?) AND column_name = ?`,
+		table, column).Scan(&typ)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return typ, err
+}
+
 func (c *Client) getTable() string {
 	if c.Table == "" {
 		return "t_shedlock"
@@ -68,93 +233,156 @@ func (c *Client) getTable() string {
 	return c.Table
 }
 
+func (c *Client) getSharedTable() string {
+	if c.SharedTable == "" {
+		return c.getTable() + "_shared"
+	}
+
+	return c.SharedTable
+}
+
+func (c *Client) getPermitTable() string {
+	if c.PermitTable == "" {
+		return c.getTable() + "_permit"
+	}
+
+	return c.PermitTable
+}
+
+func (c *Client) getWaiterTable() string {
+	if c.WaiterTable == "" {
+		return c.getTable() + "_waiter"
+	}
+
+	return c.WaiterTable
+}
+
+func (c *Client) prepare(ctx context.Context) {
+	c.Table = c.getTable()
+	c.SharedTable = c.getSharedTable()
+	c.PermitTable = c.getPermitTable()
+	c.WaiterTable = c.getWaiterTable()
+	c.autoCreateTable(ctx)
+}
+
+// View returns the present state of the lock, the row of an expired lock is kept in the table.
 func (c *Client) View(ctx context.Context, key string) (dblock.LockView, error) {
-	return view(ctx, c.client, c.getTable(), key)
+	l, err := view(ctx, c.client, c.getTable(), key)
+	if err != nil {
+		return dblock.LockView{}, err
+	}
+	if l == nil {
+		return dblock.LockView{Key: key}, nil
+	}
+	return l.view(c.now()), nil
 }
 
+// now returns the current time of the clock of the client.
+func (c *Client) now() time.Time { return now(c.options.Clock) }
+
 // Obtain tries to obtain a new lock using a key with the given TTL.
 // May return ErrNotObtained if not successful.
 func (c *Client) Obtain(ctx context.Context, key string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
-	opt := &dblock.Options{}
-	for _, f := range optionsFns {
-		f(opt)
-	}
-
-	c.Table = c.getTable()
+	return c.options.Obtain(ctx, c.obtainLock, key, ttl, optionsFns...)
+}
 
-	if !c.NotAutoCreateTable && !c.autoCreateTableChecked {
-		if _, err := c.client.ExecContext(ctx, `CREATE TABLE `+c.Table+`(lock_name VARCHAR(64) NOT NULL PRIMARY KEY, `+
-			`lock_until VARCHAR(64) NOT NULL, locked_at VARCHAR(64) NOT NULL, locked_by VARCHAR(1024) NOT NULL, `+
-			`token_value VARCHAR(64) NOT NULL, meta_value VARCHAR(1024)NOT NULL, locked_pid VARCHAR(64) NOT NULL)`); err != nil {
-			if Debug {
-				log.Printf("auto creaet table failed: %v", err)
-			}
-		}
-		c.autoCreateTableChecked = true
+func (c *Client) obtainLock(ctx context.Context, key string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
+	opt, err := c.options.ParseOptions(optionsFns...)
+	if err != nil {
+		return nil, err
 	}
+	return c.obtainWith(ctx, key, ttl, opt)
+}
 
-	token := opt.Token
+func (c *Client) obtainWith(ctx context.Context, key string, ttl time.Duration, opt *dblock.Options) (dblock.Lock, error) {
+	c.prepare(ctx)
 
-	// Create a random token
-	if token == "" {
+	var lock *Lock
+	attempt, leave := c.attempts(key, ttl, opt)
+	wake := func(ctx context.Context) <-chan struct{} { return c.released(ctx, key) }
+	err := opt.RetryWake(ctx, ttl, wake, func(ctx context.Context) (bool, error) {
 		var err error
-		if token, err = dblock.RandomToken(); err != nil {
-			return nil, err
-		}
+		lock, err = attempt(ctx)
+		return lock != nil, err
+	})
+	if err != nil {
+		leave()
+		return nil, err
 	}
 
-	retry := opt.GetRetryStrategy()
-	lockUntil := time.Now().Add(ttl)
-
-	// make sure we don't retry forever
-	if _, ok := ctx.Deadline(); !ok {
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithDeadline(ctx, lockUntil)
-		defer cancel()
+	if opt.AutoRefresh > 0 {
+		lock.refresher = dblock.StartRefresher(opt.Clock, lock, ttl, opt.AutoRefresh, opt.RefreshFailed)
 	}
+	return lock, nil
+}
 
-	var ticker *time.Ticker
-	for {
+// attempts returns the attempt to obtain the lock with the options, which returns nil lock if not obtained,
+// and the leave to call when giving up. The waiter of a fair lock is kept in the queue between the attempts.
+func (c *Client) attempts(key string, ttl time.Duration, opt *dblock.Options) (attempt func(ctx context.Context) (*Lock, error), leave func()) {
+	position := -1
+	var waiterTTL func() time.Duration
+	if opt.Fair {
+		waiterTTL = opt.WaiterTTL(ttl)
+	}
+	attempt = func(ctx context.Context) (*Lock, error) {
+		lockUntil := opt.Clock.Now().Add(ttl)
 		lockUntilStr := lockUntil.Format(time.RFC3339Nano)
-		if ok, err := c.obtain(ctx, key, token, opt.Meta, lockUntilStr); err != nil {
-			return nil, err
-		} else if ok {
-			return &Lock{
-				Client:   c,
-				Key:      key,
-				token:    token,
-				metadata: opt.Meta,
-				Until:    lockUntilStr,
-			}, nil
-		}
-
-		backoff := retry.NextBackoff()
-		if backoff < 1 {
-			return nil, dblock.ErrNotObtained
-		}
-
-		if ticker == nil {
-			ticker = time.NewTicker(backoff)
-			defer ticker.Stop()
+		var sh *shedLock
+		var ok bool
+		var err error
+		if opt.Fair {
+			// the waiter is kept in the queue until a while after its next attempt is due
+			var pos int
+			waitUntil := opt.Clock.Now().Add(waiterTTL())
+			sh, pos, err = c.obtainFair(ctx, key, opt.Token, opt.Meta, lockUntilStr, waitUntil, opt.Reentrant)
+			ok = sh != nil
+			if err == nil && !ok && pos != position {
+				position = pos
+				if opt.QueuePosition != nil {
+					opt.QueuePosition(pos)
+				}
+			}
 		} else {
-			ticker.Reset(backoff)
+			sh, ok, err = c.obtain(ctx, c.client, c.Table, key, opt.Token, opt.Meta, lockUntilStr, opt.Reentrant)
+		}
+		if err != nil || !ok {
+			return nil, err
 		}
 
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		case <-ticker.C:
+		return &Lock{
+			Client:   c,
+			Key:      key,
+			table:    c.Table,
+			token:    opt.Token,
+			metadata: sh.Meta,
+			Until:    lockUntilStr,
+			fence:    sh.Fence,
+			holds:    sh.Holds,
+			lifetime: dblock.NewLifetime(opt.Clock, lockUntil),
+		}, nil
+	}
+	leave = func() {
+		if opt.Fair {
+			w := &waiterRow{Table: c.WaiterTable, Name: key, Token: opt.Token, clock: c.options.Clock}
+			_, _ = w.leave(context.Background(), c.client)
 		}
 	}
+	return attempt, leave
 }
 
 // Lock represents an obtained, distributed lock.
 type Lock struct {
 	*Client
 	Key      string
+	table    string
 	token    string
 	metadata string
 	Until    string
+	fence    uint64
+	holds    int
+
+	lifetime  *dblock.Lifetime
+	refresher *dblock.Refresher
 }
 
 // Token returns the token value set by the lock.
@@ -163,10 +391,25 @@ func (l *Lock) Token() string { return l.token }
 // Metadata returns the metadata of the lock.
 func (l *Lock) Metadata() string { return l.metadata }
 
+// Fence returns the fencing token, which increases on every successful Obtain of the key.
+func (l *Lock) Fence() uint64 { return l.fence }
+
+// Holds returns the hold count of the key when the lock is obtained, it is greater than 1
+// when a reentrant lock is obtained again by its owner.
+func (l *Lock) Holds() int { return l.holds }
+
+// Done returns a channel that's closed when the lock is lost.
+func (l *Lock) Done() <-chan struct{} { return l.lifetime.Done() }
+
+// Context returns a copy of parent which is cancelled when the lock is lost.
+func (l *Lock) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	return l.lifetime.Context(parent)
+}
+
 // TTL returns the remaining time-to-live. Returns 0 if the lock has expired.
 func (l *Lock) TTL(ctx context.Context) (time.Duration, error) {
 	sh := &shedLock{
-		Table: l.Table,
+		Table: l.table,
 		Name:  l.Key,
 		Token: l.token,
 	}
@@ -176,6 +419,7 @@ func (l *Lock) TTL(ctx context.Context) (time.Duration, error) {
 	}
 
 	if !found {
+		l.lifetime.Lose()
 		return 0, nil
 	}
 
@@ -183,10 +427,6 @@ func (l *Lock) TTL(ctx context.Context) (time.Duration, error) {
 		sh.Meta = ""
 	}
 
-	if Debug {
-		log.Printf("found: %+v", sh)
-	}
-
 	lockUntil, err := time.Parse(time.RFC3339Nano, sh.Until)
 	if err != nil {
 		return 0, fmt.Errorf("parse lockUnitl %s: %w", sh.Until, err)
@@ -194,48 +434,61 @@ func (l *Lock) TTL(ctx context.Context) (time.Duration, error) {
 
 	l.Until = sh.Until
 
-	now := time.Now()
-	if Debug {
-		log.Printf("now: %s", now.Format(time.RFC3339Nano))
-	}
-	ttl := lockUntil.Sub(now)
-	if Debug {
-		log.Printf("ttl: %s", ttl)
-	}
+	ttl := lockUntil.Sub(l.now())
+	l.debug(ctx, "dblock: lock ttl", "key", l.Key, "token", dblock.TokenPrefix(l.token), "ttl", ttl)
 	if ttl > 0 {
 		return ttl, nil
 	}
 
+	l.lifetime.Lose()
 	return 0, nil
 }
 
 // Refresh extends the lock with a new TTL.
 // May return ErrNotObtained if refresh is unsuccessful.
 func (l *Lock) Refresh(ctx context.Context, ttl time.Duration) error {
+	until := l.now().Add(ttl)
 	sh := &shedLock{
-		Table: l.Table,
+		Table: l.table,
 		Name:  l.Key,
 		Token: l.token,
-		Until: time.Now().Add(ttl).Format(time.RFC3339Nano),
+		Until: until.Format(time.RFC3339Nano),
+		clock: l.options.Clock,
 	}
 	status, err := sh.extend(ctx, l.client)
 	if err != nil {
 		return err
 	}
 	if status {
+		l.lifetime.Extend(until)
 		return nil
 	}
+	l.lifetime.Lose()
 	return dblock.ErrNotObtained
 }
 
 // Release manually releases the lock.
 // May return ErrLockNotHeld.
 func (l *Lock) Release(ctx context.Context) error {
+	if l.refresher != nil {
+		l.refresher.Stop()
+	}
+	defer l.lifetime.Lose()
+
 	sh := &shedLock{
-		Table: l.Table,
+		Table: l.table,
 		Name:  l.Key,
 		Token: l.token,
+		clock: l.options.Clock,
 	}
+
+	// leave a reentrant lock still held by other holds
+	if left, err := sh.leave(ctx, l.client); err != nil {
+		return err
+	} else if left {
+		return nil
+	}
+
 	res, err := sh.unlock(ctx, l.client)
 	if err != nil {
 		return err
@@ -244,22 +497,43 @@ func (l *Lock) Release(ctx context.Context) error {
 		return dblock.ErrLockNotHeld
 	}
 
+	if l.table == l.Table {
+		l.notify(ctx, l.client, l.Key)
+	}
 	return nil
 }
 
-func (c *Client) obtain(ctx context.Context, key, token, meta, lockUntil string) (bool, error) {
-	sh := shedLock{
-		Table: c.Table,
+func (c *Client) obtain(ctx context.Context, db DB, table, key, token, meta, lockUntil string, reentrant bool) (*shedLock, bool, error) {
+	sh := &shedLock{
+		Table: table,
 		Name:  key,
 		Token: token,
 		Meta:  meta,
 		Until: lockUntil,
+		clock: c.options.Clock,
 	}
-	if sh.insert(ctx, c.client) {
-		return true, nil
+
+	if reentrant {
+		if ok, err := sh.reenter(ctx, db); err != nil {
+			return nil, false, err
+		} else if ok {
+			found, err := sh.query(ctx, db)
+			return sh, found, err
+		}
 	}
 
-	return sh.update(ctx, c.client)
+	// update first, a failed insert aborts the transaction in some databases, e.g. PostgreSQL.
+	if ok, err := sh.update(ctx, db); err != nil {
+		return nil, false, err
+	} else if !ok {
+		if !sh.insert(ctx, db) {
+			return nil, false, nil
+		}
+		return sh, true, nil
+	}
+
+	found, err := sh.query(ctx, db)
+	return sh, found, err
 }
 
 type shedLock struct {
@@ -271,24 +545,36 @@ type shedLock struct {
 	Token string
 	Meta  string
 	Pid   string
+	Fence uint64
+	Holds int
+
+	// clock tells the time in the statements.
+	clock dblock.Clock
 }
 
-func (l *shedLock) GetToken() string    { return l.Token }
-func (l *shedLock) GetMetadata() string { return l.Meta }
-func (l *shedLock) GetUntil() string    { return l.Until }
-func (l *shedLock) String() string {
-	return "{Token: " + l.Token + " Until: " + l.Until + " At: " + l.At + " Meta: " + l.Meta + " By: " + l.By + " PID: " + l.Pid + "}"
+// view converts the row to the view of the lock at now.
+func (l *shedLock) view(now time.Time) dblock.LockView {
+	v := dblock.LockView{Key: l.Name, Token: l.Token, Metadata: l.Meta, Holder: dblock.Holder{Host: l.By}}
+	if v.Token == NonValue {
+		v.Token = ""
+	}
+	if v.Metadata == NonValue {
+		v.Metadata = ""
+	}
+	v.AcquiredAt, _ = time.Parse(time.RFC3339Nano, l.At)
+	v.ExpiresAt, _ = time.Parse(time.RFC3339Nano, l.Until)
+	v.Holder.PID, _ = strconv.Atoi(l.Pid)
+	v.Exists = v.ExpiresAt.After(now)
+	return v
 }
 
 func view(ctx context.Context, db DB, table, lockName string) (*shedLock, error) {
-	var l shedLock
-	s := `select lock_until, locked_at, locked_by, token_value, meta_value, locked_pid from {Table} ` +
-		`WHERE lock_name = {Name}`
-	s = strings.ReplaceAll(s, "{Table}", table)
-	s = strings.ReplaceAll(s, "{Name}", singleQuote(lockName))
-
-	row := db.QueryRowContext(ctx, s)
-	if err := row.Scan(&l.Until, &l.At, &l.By, &l.Token, &l.Meta, &l.Pid); errors.Is(err, sql.ErrNoRows) {
+	l := shedLock{Table: table, Name: lockName}
+	s := l.replace(`select lock_until, locked_at, locked_by, token_value, meta_value, locked_pid, fence_value, hold_count ` +
+		`from {Table} WHERE lock_name = {Name}`)
+
+	row := db.QueryRowContext(ctx, s.query, s.args...)
+	if err := row.Scan(&l.Until, &l.At, &l.By, &l.Token, &l.Meta, &l.Pid, &l.Fence, &l.Holds); errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	} else if err != nil {
 		return nil, fmt.Errorf("query: %w", err)
@@ -298,144 +584,214 @@ func view(ctx context.Context, db DB, table, lockName string) (*shedLock, error)
 }
 
 func (l *shedLock) query(ctx context.Context, db DB) (bool, error) {
-	s := `select lock_until, locked_at, locked_by, token_value, meta_value, locked_pid from {Table} ` +
-		`WHERE lock_name = {Name} AND token_value = {Token}`
-	s = strings.ReplaceAll(s, "{Table}", l.Table)
-	s = strings.ReplaceAll(s, "{Name}", singleQuote(l.Name))
-	s = strings.ReplaceAll(s, "{Token}", singleQuote(l.Token))
-
-	row := db.QueryRowContext(ctx, s)
-	if err := row.Scan(&l.Until, &l.At, &l.By, &l.Token, &l.Meta, &l.Pid); errors.Is(err, sql.ErrNoRows) {
+	s := l.replace(`select lock_until, locked_at, locked_by, token_value, meta_value, locked_pid, fence_value, hold_count ` +
+		`from {Table} WHERE lock_name = {Name} AND token_value = {Token}`)
+
+	row := db.QueryRowContext(ctx, s.query, s.args...)
+	if err := row.Scan(&l.Until, &l.At, &l.By, &l.Token, &l.Meta, &l.Pid, &l.Fence, &l.Holds); errors.Is(err, sql.ErrNoRows) {
 		return false, nil
 	} else if err != nil {
 		return false, fmt.Errorf("query: %w", err)
 	}
 
+	if l.Meta == NonValue {
+		l.Meta = ""
+	}
 	return true, nil
 }
 
 func (l *shedLock) insert(ctx context.Context, db DB) bool {
-	s := `INSERT INTO {Table} (lock_name, lock_until, locked_at, locked_by, token_value, meta_value, locked_pid) ` +
-		`VALUES ({Name}, {Until}, {At}, {By}, {Token}, {Meta}, {LockedPid})`
-	s = strings.ReplaceAll(s, "{Table}", l.Table)
-	s = strings.ReplaceAll(s, "{Name}", singleQuote(l.Name))
-	s = strings.ReplaceAll(s, "{Until}", singleQuote(l.Until))
-	s = strings.ReplaceAll(s, "{At}", singleQuote(time.Now().Format(time.RFC3339Nano)))
-	s = strings.ReplaceAll(s, "{By}", singleQuote(Hostname))
-	s = strings.ReplaceAll(s, "{Token}", singleQuote(l.Token))
-	s = strings.ReplaceAll(s, "{Meta}", singleQuote(l.Meta))
-	s = strings.ReplaceAll(s, "{LockedPid}", singleQuote(Pid))
-
-	if _, err := db.ExecContext(ctx, s); err == nil {
+	// a failed insert aborts the transaction in some databases, e.g. PostgreSQL,
+	// roll it back to a savepoint to go on with the transaction.
+	_, inTx := db.(txDb)
+	if inTx {
+		if _, err := db.ExecContext(ctx, `SAVEPOINT dblock_insert`); err != nil {
+			return false
+		}
+	}
+
+	s := l.replace(`INSERT INTO {Table} (lock_name, lock_until, locked_at, locked_by, token_value, meta_value, locked_pid, fence_value, hold_count) ` +
+		`VALUES ({Name}, {Until}, {Now}, {By}, {Token}, {Meta}, {LockedPid}, 1, 1)`)
+
+	if _, err := db.ExecContext(ctx, s.query, s.args...); err == nil {
+		l.Fence, l.Holds = 1, 1
 		return true
 	}
 
+	if inTx {
+		_, _ = db.ExecContext(ctx, `ROLLBACK TO SAVEPOINT dblock_insert`)
+	}
 	return false
 }
 
 func (l *shedLock) update(ctx context.Context, db DB) (bool, error) {
-	s := `UPDATE {Table} SET lock_until = {Until}, ` +
-		`locked_at = {At}, locked_by = {By}, ` +
-		`token_value = {Token}, meta_value = {Meta}, locked_pid = {LockedPid} ` +
-		`WHERE lock_name = {Name} AND (token_value = {Token} or lock_until <= {Now} )`
-	s = strings.ReplaceAll(s, "{Table}", l.Table)
-	s = strings.ReplaceAll(s, "{Name}", singleQuote(l.Name))
-	s = strings.ReplaceAll(s, "{Until}", singleQuote(l.Until))
-	now := singleQuote(time.Now().Format(time.RFC3339Nano))
-	s = strings.ReplaceAll(s, "{Now}", now)
-	s = strings.ReplaceAll(s, "{At}", now)
-	s = strings.ReplaceAll(s, "{By}", singleQuote(Hostname))
-	s = strings.ReplaceAll(s, "{Token}", singleQuote(l.Token))
-	s = strings.ReplaceAll(s, "{Meta}", singleQuote(l.Meta))
-	s = strings.ReplaceAll(s, "{LockedPid}", singleQuote(Pid))
-
-	result, err := db.ExecContext(ctx, s)
-	if err != nil {
-		return false, fmt.Errorf("update lock %q : %w", s, err)
-	}
+	return execAffected(ctx, db, l.replace(`UPDATE {Table} SET lock_until = {Until}, `+
+		`locked_at = {Now}, locked_by = {By}, `+
+		`token_value = {Token}, meta_value = {Meta}, locked_pid = {LockedPid}, fence_value = fence_value + 1, hold_count = 1 `+
+		`WHERE lock_name = {Name} AND (token_value = {Token} or lock_until <= {Now} )`))
+}
 
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return false, fmt.Errorf("RowsAffected: %w", err)
-	}
+// reenter obtains the lock held by the same token again.
+func (l *shedLock) reenter(ctx context.Context, db DB) (bool, error) {
+	return execAffected(ctx, db, l.replace(`UPDATE {Table} SET lock_until = {Until}, hold_count = hold_count + 1 `+
+		`WHERE lock_name = {Name} AND token_value = {Token} AND lock_until > {Now}`))
+}
 
-	return rowsAffected > 0, nil
+// leave decreases the hold count of a reentrant lock, which is still held by other holds.
+func (l *shedLock) leave(ctx context.Context, db DB) (bool, error) {
+	return execAffected(ctx, db, l.replace(`UPDATE {Table} SET hold_count = hold_count - 1 `+
+		`WHERE lock_name = {Name} AND token_value = {Token} AND hold_count > 1 AND lock_until > {Now}`))
 }
 
 func (l *shedLock) extend(ctx context.Context, db DB) (bool, error) {
-	s := `UPDATE {Table} SET lock_until = {Until} ` +
-		`WHERE lock_name = {Name} AND token_value = {Token}`
-	s = strings.ReplaceAll(s, "{Table}", l.Table)
-	s = strings.ReplaceAll(s, "{Name}", singleQuote(l.Name))
-	s = strings.ReplaceAll(s, "{Until}", singleQuote(l.Until))
-	s = strings.ReplaceAll(s, "{Token}", singleQuote(l.Token))
-	result, err := db.ExecContext(ctx, s)
-	if err != nil {
-		return false, fmt.Errorf("update lock %q : %w", s, err)
+	return execAffected(ctx, db, l.replace(`UPDATE {Table} SET lock_until = {Until} `+
+		`WHERE lock_name = {Name} AND token_value = {Token}`))
+}
+
+func (l *shedLock) unlock(ctx context.Context, db DB) (bool, error) {
+	l.Until = now(l.clock).Add(-time.Second).Format(time.RFC3339Nano)
+	return execAffected(ctx, db, l.replace(`UPDATE {Table} SET lock_until = {Until} `+
+		`WHERE lock_name = {Name} AND token_value = {Token}`))
+}
+
+// NonValue is kept for the empty strings, e.g. the empty metadata.
+const NonValue = "(nil)"
+
+// Dialect is the SQL dialect of the DB.
+type Dialect int
+
+const (
+	// MySQL uses the ? bind variables.
+	MySQL Dialect = iota
+	// PostgreSQL uses the $1, $2... bind variables.
+	PostgreSQL
+)
+
+// detectDialect detects the dialect by the package of the driver of a *sql.DB,
+// e.g. github.com/lib/pq and github.com/jackc/pgx, MySQL if unknown.
+func detectDialect(client DB) Dialect {
+	db, ok := client.(*sql.DB)
+	if !ok || db == nil {
+		return MySQL
 	}
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return false, fmt.Errorf("RowsAffected: %w", err)
+
+	t := reflect.TypeOf(db.Driver())
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if pkg := t.PkgPath(); strings.HasSuffix(pkg, "/lib/pq") || strings.Contains(pkg, "/pgx") ||
+		strings.Contains(pkg, "postgres") {
+		return PostgreSQL
+	}
+	return MySQL
+}
+
+// rebind replaces the ? bind variables of the query by the ones of the dialect.
+func (d Dialect) rebind(query string) string {
+	if d != PostgreSQL || !strings.Contains(query, "?") {
+		return query
 	}
 
-	return rowsAffected > 0, nil
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
 }
 
-func (l *shedLock) unlock(ctx context.Context, db DB) (bool, error) {
-	l.Until = time.Now().Add(-time.Second).Format(time.RFC3339Nano)
-	s := `UPDATE {Table} SET lock_until = {Until} ` +
-		`WHERE lock_name = {Name} AND token_value = {Token}`
-	s = strings.ReplaceAll(s, "{Table}", l.Table)
-	s = strings.ReplaceAll(s, "{Name}", singleQuote(l.Name))
-	s = strings.ReplaceAll(s, "{Until}", singleQuote(l.Until))
-	s = strings.ReplaceAll(s, "{Token}", singleQuote(l.Token))
-	result, err := db.ExecContext(ctx, s)
-	if err != nil {
-		return false, fmt.Errorf("update lock %q : %w", s, err)
+// currentSchema returns the function of the current schema, where the tables are created.
+func (d Dialect) currentSchema() string {
+	if d == PostgreSQL {
+		return `current_schema()`
 	}
-	rowsAffected, err := result.RowsAffected()
-	if err != nil {
-		return false, fmt.Errorf("RowsAffected: %w", err)
+	return `DATABASE()`
+}
+
+// alterText returns the statement to change the type of the column to TEXT.
+func (d Dialect) alterText(table, column string) string {
+	if d == PostgreSQL {
+		return `ALTER TABLE ` + table + ` ALTER COLUMN ` + column + ` TYPE TEXT`
 	}
+	return `ALTER TABLE ` + table + ` MODIFY ` + column + ` TEXT NOT NULL`
+}
 
-	return rowsAffected > 0, nil
+// stmt is a statement with the values of its bind variables.
+type stmt struct {
+	query string
+	args  []any
 }
 
-const (
-	quote  = '\''
-	escape = '\\'
-)
+// metaArg is the metadata bound in the statements, which is redacted in the logs.
+type metaArg string
 
-const NonValue = "(nil)"
+// Value implements driver.Valuer.
+func (m metaArg) Value() (driver.Value, error) { return string(m), nil }
 
-// singleQuote returns a single-quoted Go string literal representing s. But, nothing else escapes.
-func singleQuote(s string) string {
-	if s == "" {
-		s = NonValue
-	}
-	out := []rune{quote}
-	for _, r := range s {
-		switch r {
-		case quote:
-			out = append(out, escape, r)
+// ident is an identifier written inline in the statements, like the table names.
+type ident string
+
+// bind replaces the {Name} placeholders of s by the identifiers inline, or by the ? bind variables of the other values,
+// the empty strings are bound as NonValue.
+func bind(s string, values map[string]any) stmt {
+	var st stmt
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '{')
+		j := strings.IndexByte(s[i+1:], '}')
+		if i < 0 || j < 0 {
+			break
+		}
+		name, rest := s[i+1:i+1+j], s[i+2+j:]
+		v, ok := values[name]
+		if !ok {
+			b.WriteString(s[:i+2+j])
+			s = rest
+			continue
+		}
+
+		b.WriteString(s[:i])
+		switch v := v.(type) {
+		case ident:
+			b.WriteString(string(v))
+		case string:
+			if v == "" {
+				v = NonValue
+			}
+			b.WriteByte('?')
+			st.args = append(st.args, v)
+		case metaArg:
+			if v == "" {
+				v = NonValue
+			}
+			b.WriteByte('?')
+			st.args = append(st.args, v)
 		default:
-			out = append(out, r)
+			b.WriteByte('?')
+			st.args = append(st.args, v)
 		}
+		s = rest
 	}
-	out = append(out, quote)
-	return string(out)
+	b.WriteString(s)
+	st.query = b.String()
+	return st
 }
 
-var Hostname = func() string {
-	hostname, err := os.Hostname()
-	if err != nil {
-		return err.Error()
+// now returns the current time of the clock, of the system if not set.
+func now(clock dblock.Clock) time.Time {
+	if clock == nil {
+		return time.Now()
 	}
+	return clock.Now()
+}
 
-	return hostname
-}()
+// Hostname is recorded in locked_by.
+var Hostname = dblock.Hostname
 
-var Pid = func() string {
-	pid := os.Getpid()
-	return strconv.Itoa(pid)
-}()
+// Pid is recorded in locked_pid.
+var Pid = strconv.Itoa(dblock.Pid)
@@ -0,0 +1,781 @@
+package rdblock_test
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+	"github.com/bingoohuang/dblock/clocktest"
+	"github.com/bingoohuang/dblock/rdblock"
+)
+
+const (
+	lockKey   = "__rdblock_unit_test__"
+	otherKey  = "__rdblock_unit_test_other__"
+	testTable = "t_rdblock_unit_test"
+)
+
+// newClient creates a client on the test tables, which are dropped by teardown.
+func newClient(db *sql.DB, optionsFns ...dblock.ClientOptionsFn) *rdblock.Client {
+	client := rdblock.New(db, optionsFns...)
+	client.Table = testTable
+	return client
+}
+
+func TestLock_TTL_clock(t *testing.T) {
+	ctx := context.Background()
+	db := openDB()
+	defer teardown(t, db)
+
+	clock := clocktest.NewFakeClock(time.Now())
+	client := newClient(db, dblock.WithClock(clock))
+
+	lock, err := client.Obtain(ctx, lockKey, 100*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release(ctx)
+
+	clock.Advance(50 * time.Millisecond)
+	assertTTL(t, lock, 50*time.Millisecond)
+
+	if err := lock.Refresh(ctx, 100*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(100 * time.Millisecond)
+	assertTTL(t, lock, 0)
+	select {
+	case <-lock.Done():
+	default:
+		t.Fatal("expected the lock to be lost")
+	}
+}
+
+func TestObtain_reentrant(t *testing.T) {
+	ctx := context.Background()
+	db := openDB()
+	defer teardown(t, db)
+
+	client := newClient(db)
+	lock1, err := client.Obtain(ctx, lockKey, time.Hour, dblock.WithToken("foo"), dblock.WithReentrant())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock2, err := client.Obtain(ctx, lockKey, time.Hour, dblock.WithToken("foo"), dblock.WithReentrant())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exp, got := 2, dblock.Holds(lock2); exp != got {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+
+	// the first release keeps the lock held for the other hold
+	if err := lock2.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Obtain(ctx, lockKey, time.Hour); !errors.Is(err, dblock.ErrNotObtained) {
+		t.Fatalf("expected %v, got %v", dblock.ErrNotObtained, err)
+	}
+
+	if err := lock1.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	lock3, err := client.Obtain(ctx, lockKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock3.Release(ctx)
+}
+
+func TestObtain_reentrant_afterPlain(t *testing.T) {
+	ctx := context.Background()
+	db := openDB()
+	defer teardown(t, db)
+
+	client := newClient(db)
+
+	// the plain obtain counts as the first hold
+	lock1, err := client.Obtain(ctx, lockKey, time.Hour, dblock.WithToken("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock2, err := client.Obtain(ctx, lockKey, time.Hour, dblock.WithToken("foo"), dblock.WithReentrant())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, got := 2, dblock.Holds(lock2); exp != got {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+
+	if err := lock2.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Obtain(ctx, lockKey, time.Hour); !errors.Is(err, dblock.ErrNotObtained) {
+		t.Fatalf("expected %v, got %v", dblock.ErrNotObtained, err)
+	}
+	if err := lock1.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_ObtainShared(t *testing.T) {
+	ctx := context.Background()
+	db := openDB()
+	defer teardown(t, db)
+
+	client := newClient(db)
+
+	// many shared holders at once
+	shared1, err := client.ObtainShared(ctx, lockKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared2, err := client.ObtainShared(ctx, lockKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl, err := shared2.TTL(ctx); err != nil || ttl <= 0 || ttl > time.Hour {
+		t.Fatalf("expected ~%v, got %v, %v", time.Hour, ttl, err)
+	}
+
+	// the exclusive lock waits for the shared holders
+	_, err = client.ObtainExclusive(ctx, lockKey, time.Hour, dblock.WithRetryStrategy(
+		dblock.LimitRetry(dblock.LinearBackoff(5*time.Millisecond), 2),
+	))
+	if exp, got := dblock.ErrNotObtained, err; !errors.Is(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+
+	for _, lock := range []dblock.Lock{shared1, shared2} {
+		if err := lock.Release(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exclusive, err := client.ObtainExclusive(ctx, lockKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exclusive.Release(ctx)
+
+	// no shared holders while the exclusive lock is held
+	_, err = client.ObtainShared(ctx, lockKey, time.Hour)
+	if exp, got := dblock.ErrNotObtained, err; !errors.Is(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+}
+
+func TestClient_AcquirePermit(t *testing.T) {
+	ctx := context.Background()
+	db := openDB()
+	defer teardown(t, db)
+
+	client := newClient(db)
+
+	permit1, err := client.AcquirePermit(ctx, lockKey, 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	permit2, err := client.AcquirePermit(ctx, lockKey, 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer permit2.Release(ctx)
+
+	// no more permits
+	if _, err := client.AcquirePermit(ctx, lockKey, 2, time.Hour); !errors.Is(err, dblock.ErrNotObtained) {
+		t.Fatalf("expected %v, got %v", dblock.ErrNotObtained, err)
+	}
+
+	if err := permit1.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	permit3, err := client.AcquirePermit(ctx, lockKey, 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer permit3.Release(ctx)
+}
+
+func TestClient_AcquirePermit_expired(t *testing.T) {
+	ctx := context.Background()
+	db := openDB()
+	defer teardown(t, db)
+
+	clock := clocktest.NewFakeClock(time.Now())
+	client := newClient(db, dblock.WithClock(clock))
+	if _, err := client.AcquirePermit(ctx, lockKey, 1, 5*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	// the expired permit is reclaimed
+	clock.Advance(10 * time.Millisecond)
+	permit, err := client.AcquirePermit(ctx, lockKey, 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer permit.Release(ctx)
+}
+
+func TestClient_ObtainMulti(t *testing.T) {
+	ctx := context.Background()
+	db := openDB()
+	defer teardown(t, db)
+
+	client := newClient(db)
+
+	// one of the keys is held by others
+	other, err := client.Obtain(ctx, otherKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ObtainMulti(ctx, []string{lockKey, otherKey}, time.Hour); !errors.Is(err, dblock.ErrNotObtained) {
+		t.Fatalf("expected %v, got %v", dblock.ErrNotObtained, err)
+	}
+	// none of them is obtained, the transaction is rolled back
+	lock, err := client.Obtain(ctx, lockKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := other.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	multi, err := client.ObtainMulti(ctx, []string{otherKey, lockKey, otherKey}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, got := []string{lockKey, otherKey}, multi.Keys(); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	if _, err := client.Obtain(ctx, otherKey, time.Hour); !errors.Is(err, dblock.ErrNotObtained) {
+		t.Fatalf("expected %v, got %v", dblock.ErrNotObtained, err)
+	}
+	if err := multi.Refresh(ctx, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, err := multi.TTL(ctx); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("expected ~%v, got %v, %v", time.Minute, ttl, err)
+	}
+	if err := multi.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// all the keys are released at once
+	for _, key := range []string{lockKey, otherKey} {
+		lock, err := client.Obtain(ctx, key, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer lock.Release(ctx)
+	}
+}
+
+func TestObtain_fair(t *testing.T) {
+	ctx := context.Background()
+	db := openDB()
+	defer teardown(t, db)
+
+	client := newClient(db)
+	holder, err := client.Obtain(ctx, lockKey, time.Hour, dblock.WithFair())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := make(chan string, 2)
+	var wg sync.WaitGroup
+	for i, name := range []string{"first", "second"} {
+		positions := make(chan int, 10)
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+
+			lock, err := client.Obtain(ctx, lockKey, time.Second, dblock.WithFair(),
+				dblock.WithRetryStrategy(dblock.LinearBackoff(5*time.Millisecond)),
+				dblock.WithQueuePosition(func(position int) { positions <- position }))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			order <- name
+			_ = lock.Release(ctx)
+		}(name)
+
+		// queue the waiters in order, the second one is behind the first one
+		if exp, got := i, <-positions; exp != got {
+			t.Fatalf("expected %v, got %v", exp, got)
+		}
+	}
+
+	if err := holder.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	wg.Wait()
+	for _, exp := range []string{"first", "second"} {
+		if got := <-order; exp != got {
+			t.Fatalf("expected %v, got %v", exp, got)
+		}
+	}
+}
+
+func TestObtain_fair_held(t *testing.T) {
+	ctx := context.Background()
+	db := openDB()
+	defer teardown(t, db)
+
+	client := newClient(db)
+	holder, err := client.Obtain(ctx, lockKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Release(ctx)
+
+	// the waiter at the head of the queue fails to insert the held lock, in the transaction joining the queue
+	position := -1
+	_, err = client.Obtain(ctx, lockKey, time.Hour, dblock.WithFair(),
+		dblock.WithQueuePosition(func(p int) { position = p }))
+	if !errors.Is(err, dblock.ErrNotObtained) {
+		t.Fatalf("expected ErrNotObtained, got %v", err)
+	}
+	if exp, got := 0, position; exp != got {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+}
+
+func TestNew_dialect(t *testing.T) {
+	db := openDB()
+	defer db.Close()
+
+	// not a PostgreSQL driver
+	if exp, got := rdblock.MySQL, rdblock.New(db).Dialect; exp != got {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	if exp, got := rdblock.MySQL, rdblock.New(nil).Dialect; exp != got {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+}
+
+func TestClient_Channel(t *testing.T) {
+	client := newClient(nil)
+
+	channel := client.Channel(lockKey)
+	if !strings.HasPrefix(channel, testTable+"_") || len(channel) > 63 {
+		t.Fatalf("expected a PostgreSQL identifier prefixed by %s, got %s", testTable, channel)
+	}
+	if exp, got := channel, client.Channel(lockKey); exp != got {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	if channel == client.Channel(otherKey) {
+		t.Fatalf("expected different channels for %s and %s", lockKey, otherKey)
+	}
+}
+
+// fakeWakeSource notifies the waiters of a channel by the test.
+type fakeWakeSource struct {
+	mu       sync.Mutex
+	channels map[string]chan struct{}
+}
+
+func (l *fakeWakeSource) Listen(_ context.Context, channel string) (<-chan struct{}, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.channels == nil {
+		l.channels = make(map[string]chan struct{})
+	}
+	if l.channels[channel] == nil {
+		l.channels[channel] = make(chan struct{}, 1)
+	}
+	return l.channels[channel], nil
+}
+
+func (l *fakeWakeSource) listening(channel string) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.channels[channel]
+}
+
+func TestObtain_wakeup(t *testing.T) {
+	ctx := context.Background()
+	db := openDB()
+	defer teardown(t, db)
+
+	source := &fakeWakeSource{}
+	client := newClient(db)
+	// the NOTIFY fails out of PostgreSQL, which is ignored
+	client.Notify = true
+	client.WakeSource = source
+
+	holder, err := client.Obtain(ctx, lockKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	channel := client.Channel(lockKey)
+	go func() {
+		for source.listening(channel) == nil {
+			time.Sleep(5 * time.Millisecond)
+		}
+		if err := holder.Release(ctx); err != nil {
+			t.Error(err)
+		}
+		source.listening(channel) <- struct{}{}
+	}()
+
+	// woken up by the notification, rather than the retrying ticker
+	start := time.Now()
+	lock, err := client.Obtain(ctx, lockKey, time.Hour, dblock.WithRetryStrategy(dblock.LinearBackoff(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release(ctx)
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected woken up at once, got %v", elapsed)
+	}
+}
+
+func TestClient_List(t *testing.T) {
+	ctx := context.Background()
+	db := openDB()
+	defer teardown(t, db)
+
+	clock := clocktest.NewFakeClock(time.Now())
+	client := newClient(db, dblock.WithClock(clock))
+	// the wildcards in the prefix are matched literally
+	for _, key := range []string{otherKey, lockKey, "xxrdblockxunitxtestxx", "50%!_off", "50x!xoff"} {
+		lock, err := client.Obtain(ctx, key, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer lock.Release(ctx)
+	}
+	if _, err := client.Obtain(ctx, lockKey+"expired", 5*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(10 * time.Millisecond)
+
+	for prefix, exp := range map[string][]string{
+		"__rdblock_unit_test_": {lockKey, otherKey},
+		"50%!_":                {"50%!_off"},
+	} {
+		views, err := client.List(ctx, prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := viewKeys(views); !reflect.DeepEqual(exp, got) {
+			t.Fatalf("expected %v, got %v", exp, got)
+		}
+	}
+
+	// the expired lock is listed only by ListAll
+	views, err := client.ListAll(ctx, lockKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, got := []string{lockKey, lockKey + "expired"}, viewKeys(views); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+}
+
+func viewKeys(views []dblock.LockView) []string {
+	var keys []string
+	for _, view := range views {
+		keys = append(keys, view.Key)
+	}
+	return keys
+}
+
+func TestClient_ForceRelease(t *testing.T) {
+	ctx := context.Background()
+	db := openDB()
+	defer teardown(t, db)
+
+	clock := clocktest.NewFakeClock(time.Now().Add(time.Hour))
+	client := newClient(db, dblock.WithClock(clock))
+	lock, err := client.Obtain(ctx, lockKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ForceRelease(ctx, lockKey, "host died"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ForceRelease(ctx, lockKey, "host died"); !errors.Is(err, dblock.ErrLockNotHeld) {
+		t.Fatalf("expected %v, got %v", dblock.ErrLockNotHeld, err)
+	}
+	if err := lock.Refresh(ctx, time.Hour); !errors.Is(err, dblock.ErrNotObtained) {
+		t.Fatalf("expected %v, got %v", dblock.ErrNotObtained, err)
+	}
+	// the record is kept in the row, at the time of the client clock
+	record := " at " + clock.Now().Format(time.RFC3339) + ": host died"
+	if view, err := client.View(ctx, lockKey); err != nil || view.Exists || !strings.HasSuffix(view.Metadata, record) {
+		t.Fatalf("expected the record, got %+v, %v", view, err)
+	}
+}
+
+func TestClient_Steal(t *testing.T) {
+	ctx := context.Background()
+	db := openDB()
+	defer teardown(t, db)
+
+	clock := clocktest.NewFakeClock(time.Now().Add(time.Hour))
+	client := newClient(db, dblock.WithClock(clock))
+	lock, err := client.Obtain(ctx, lockKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stolen, err := client.Steal(ctx, lockKey, time.Minute, "host died")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stolen.Release(ctx)
+
+	record := " at " + clock.Now().Format(time.RFC3339) + ": host died"
+	if !strings.HasPrefix(stolen.Metadata(), "stolen by ") || !strings.HasSuffix(stolen.Metadata(), record) {
+		t.Fatalf("expected the record, got %q", stolen.Metadata())
+	}
+	if exp, got := lock.Fence()+1, stolen.Fence(); exp != got {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	if err := lock.Release(ctx); !errors.Is(err, dblock.ErrLockNotHeld) {
+		t.Fatalf("expected %v, got %v", dblock.ErrLockNotHeld, err)
+	}
+	if ttl, err := stolen.TTL(ctx); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("expected ~%v, got %v, %v", time.Minute, ttl, err)
+	}
+}
+
+func assertTTL(t *testing.T, lock dblock.Lock, exp time.Duration) {
+	t.Helper()
+
+	ttl, err := lock.TTL(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl != exp {
+		t.Fatalf("expected TTL %v, got %v", exp, ttl)
+	}
+}
+
+func teardown(t *testing.T, db *sql.DB) {
+	t.Helper()
+
+	for _, table := range []string{testTable, testTable + "_shared", testTable + "_permit", testTable + "_waiter"} {
+		if _, err := db.Exec("DROP TABLE IF EXISTS " + table); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestObtain_metadataQuoted(t *testing.T) {
+	ctx := context.Background()
+	db := openDB()
+	defer teardown(t, db)
+
+	client := newClient(db)
+	// the quotes and the backslashes are kept as they are, e.g. in JSON
+	meta := `{"path":"C:\\tmp\\it's","name":"\"x\""}`
+	lock, err := client.Obtain(ctx, lockKey, time.Hour, dblock.WithMeta(meta))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release(ctx)
+
+	view, err := client.View(ctx, lockKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !view.Exists || view.Metadata != meta {
+		t.Fatalf("expected metadata %s, got %+v", meta, view)
+	}
+}
+
+func TestClient_logger_redact(t *testing.T) {
+	ctx := context.Background()
+	db := openDB()
+	defer teardown(t, db)
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	client := newClient(db, dblock.WithLogger(logger), dblock.WithRedactMetadata())
+	lock, err := client.Obtain(ctx, lockKey, time.Hour, dblock.WithMeta("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release(ctx)
+
+	// the statements are logged with the values, only the metadata is redacted
+	log := buf.String()
+	if !strings.Contains(log, "INSERT INTO "+testTable) || !strings.Contains(log, lockKey) {
+		t.Fatalf("expected the statements logged, got %s", log)
+	}
+	if strings.Contains(log, "secret") || !strings.Contains(log, dblock.RedactedMetadata) {
+		t.Fatalf("expected the metadata redacted, got %s", log)
+	}
+}
+
+func TestClient_autoCreateTable_upToDate(t *testing.T) {
+	ctx := context.Background()
+	db := openDB()
+	defer teardown(t, db)
+
+	lock, err := newClient(db).Obtain(ctx, lockKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the tables are up-to-date, no upgrades run when another client starts
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	lock, err = newClient(db, dblock.WithLogger(logger)).Obtain(ctx, lockKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release(ctx)
+
+	if log := buf.String(); strings.Contains(log, "ALTER TABLE") {
+		t.Fatalf("expected no upgrades, got %s", log)
+	}
+}
+
+func TestClient_ObtainExclusive_waitReaders(t *testing.T) {
+	ctx := context.Background()
+	db := openDB()
+	defer teardown(t, db)
+
+	client := newClient(db)
+	if _, err := client.ObtainShared(ctx, lockKey, 150*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	// the writer waits for the reader longer than its own ttl, and keeps the lock meanwhile
+	exclusive, err := client.ObtainExclusive(ctx, lockKey, 50*time.Millisecond,
+		dblock.WithRetryStrategy(dblock.LinearBackoff(10*time.Millisecond)), dblock.WithWaitTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exclusive.Release(ctx)
+
+	// not lost and obtained again while waiting
+	if exclusive.Fence() != 1 {
+		t.Fatalf("expected the lock obtained once, got fence %d", exclusive.Fence())
+	}
+	if ttl, err := exclusive.TTL(ctx); err != nil || ttl <= 0 {
+		t.Fatalf("expected the lock held, got %v, %v", ttl, err)
+	}
+	if _, err := client.Obtain(ctx, lockKey, time.Hour); !errors.Is(err, dblock.ErrNotObtained) {
+		t.Fatalf("expected %v, got %v", dblock.ErrNotObtained, err)
+	}
+	if _, err := client.ObtainShared(ctx, lockKey, time.Hour); !errors.Is(err, dblock.ErrNotObtained) {
+		t.Fatalf("expected %v, got %v", dblock.ErrNotObtained, err)
+	}
+}
+
+func TestClient_ObtainExclusive_fair(t *testing.T) {
+	ctx := context.Background()
+	db := openDB()
+	defer teardown(t, db)
+
+	client := newClient(db)
+	holder, err := client.Obtain(ctx, lockKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the exclusive waiter is at the head of the queue
+	attempts := make(chan int, 10)
+	waitCtx := dblock.WithRetryTrace(ctx, &dblock.RetryTrace{
+		AttemptDone: func(attempt int, _ bool, _ error) { attempts <- attempt },
+	})
+	obtained := make(chan error, 1)
+	go func() {
+		lock, err := client.ObtainExclusive(waitCtx, lockKey, time.Hour, dblock.WithFair(),
+			dblock.WithRetryStrategy(dblock.LinearBackoff(50*time.Millisecond)), dblock.WithWaitTimeout(time.Minute))
+		if err == nil {
+			err = lock.Release(ctx)
+		}
+		obtained <- err
+	}()
+	for <-attempts < 2 {
+	}
+
+	// and kept in the queue between its attempts, the later waiter is queued behind
+	var position int
+	_, err = client.Obtain(ctx, lockKey, time.Hour, dblock.WithFair(),
+		dblock.WithQueuePosition(func(p int) { position = p }))
+	if !errors.Is(err, dblock.ErrNotObtained) {
+		t.Fatalf("expected %v, got %v", dblock.ErrNotObtained, err)
+	}
+	if position != 1 {
+		t.Fatalf("expected 1 waiter ahead, got %d", position)
+	}
+
+	if err := holder.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-obtained; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestObtain_fair_clockSkew(t *testing.T) {
+	ctx := context.Background()
+	db := openDB()
+	defer teardown(t, db)
+
+	client := newClient(db)
+	holder, err := client.Obtain(ctx, lockKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Release(ctx)
+
+	// the first waiter keeps waiting at the head of the queue
+	waitCtx, cancel := context.WithCancel(ctx)
+	first := make(chan int, 10)
+	done := make(chan struct{})
+	defer func() {
+		cancel()
+		<-done
+	}()
+	go func() {
+		defer close(done)
+		_, _ = client.Obtain(waitCtx, lockKey, time.Hour, dblock.WithFair(), dblock.WithBlocking(),
+			dblock.WithRetryStrategy(dblock.LinearBackoff(10*time.Millisecond)),
+			dblock.WithQueuePosition(func(position int) { first <- position }))
+	}()
+	if position := <-first; position != 0 {
+		t.Fatalf("expected the head of the queue, got %d", position)
+	}
+
+	// the later waiter is queued behind, even if its clock is an hour behind
+	skewed := newClient(db, dblock.WithClock(dblock.OffsetClock(dblock.SystemClock, -time.Hour)))
+	var position int
+	_, err = skewed.Obtain(ctx, lockKey, time.Hour, dblock.WithFair(),
+		dblock.WithQueuePosition(func(p int) { position = p }))
+	if !errors.Is(err, dblock.ErrNotObtained) {
+		t.Fatalf("expected %v, got %v", dblock.ErrNotObtained, err)
+	}
+	if position != 1 {
+		t.Fatalf("expected 1 waiter ahead, got %d", position)
+	}
+}
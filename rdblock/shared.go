@@ -0,0 +1,279 @@
+package rdblock
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+)
+
+// ObtainShared tries to obtain a shared lock using a key with the given TTL,
+// many shared holders can hold the key at once, while no exclusive lock is held.
+// May return ErrNotObtained if not successful.
+func (c *Client) ObtainShared(ctx context.Context, key string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
+	return c.options.Obtain(ctx, c.obtainShared, key, ttl, optionsFns...)
+}
+
+func (c *Client) obtainShared(ctx context.Context, key string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
+	opt, err := c.options.ParseOptions(optionsFns...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.prepare(ctx)
+
+	var lock *sharedLock
+	err = opt.Retry(ctx, ttl, func(ctx context.Context) (bool, error) {
+		lockUntil := opt.Clock.Now().Add(ttl)
+		sh := &sharedRow{
+			Table: c.SharedTable,
+			Locks: c.Table,
+			Name:  key,
+			Token: opt.Token,
+			Meta:  opt.Meta,
+			Until: lockUntil.Format(time.RFC3339Nano),
+			clock: c.options.Clock,
+		}
+		ok, err := sh.insert(ctx, c.client)
+		if err != nil || !ok {
+			return false, err
+		}
+
+		lock = &sharedLock{
+			Client:   c,
+			Key:      key,
+			token:    opt.Token,
+			metadata: opt.Meta,
+			lifetime: dblock.NewLifetime(opt.Clock, lockUntil),
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if opt.AutoRefresh > 0 {
+		lock.refresher = dblock.StartRefresher(opt.Clock, lock, ttl, opt.AutoRefresh, opt.RefreshFailed)
+	}
+	return lock, nil
+}
+
+// ObtainExclusive tries to obtain an exclusive lock using a key with the given TTL,
+// it blocks new shared holders at once, and waits until the present shared holders are gone.
+// May return ErrNotObtained if not successful.
+func (c *Client) ObtainExclusive(ctx context.Context, key string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
+	return c.options.Obtain(ctx, c.obtainExclusive, key, ttl, optionsFns...)
+}
+
+func (c *Client) obtainExclusive(ctx context.Context, key string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
+	opt, err := c.options.ParseOptions(optionsFns...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.prepare(ctx)
+
+	// the exclusive lock blocks new shared holders, wait for the present ones to leave.
+	// the waiter of a fair lock is kept in the queue until obtained or given up.
+	sh := &sharedRow{Table: c.SharedTable, Name: key, clock: c.options.Clock}
+	attempt, leave := c.attempts(key, ttl, opt)
+	obtain := func(ctx context.Context, opt *dblock.Options) (dblock.Lock, error) {
+		lock, err := attempt(ctx)
+		if err != nil {
+			return nil, err
+		} else if lock == nil {
+			return nil, dblock.ErrNotObtained
+		}
+		if opt.AutoRefresh > 0 {
+			lock.refresher = dblock.StartRefresher(opt.Clock, lock, ttl, opt.AutoRefresh, opt.RefreshFailed)
+		}
+		return lock, nil
+	}
+	lock, err := opt.ObtainDrained(ctx, ttl, obtain, func(ctx context.Context) (bool, error) {
+		n, err := sh.count(ctx, c.client)
+		return n == 0, err
+	})
+	if err != nil {
+		leave()
+	}
+	return lock, err
+}
+
+// sharedLock represents an obtained shared lock.
+type sharedLock struct {
+	*Client
+	Key      string
+	token    string
+	metadata string
+
+	lifetime  *dblock.Lifetime
+	refresher *dblock.Refresher
+}
+
+// Token returns the token value set by the lock.
+func (l *sharedLock) Token() string { return l.token }
+
+// Metadata returns the metadata of the lock.
+func (l *sharedLock) Metadata() string { return l.metadata }
+
+// Fence returns 0, the shared locks are not fenced.
+func (l *sharedLock) Fence() uint64 { return 0 }
+
+// Done returns a channel that's closed when the lock is lost.
+func (l *sharedLock) Done() <-chan struct{} { return l.lifetime.Done() }
+
+// Context returns a copy of parent which is cancelled when the lock is lost.
+func (l *sharedLock) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	return l.lifetime.Context(parent)
+}
+
+func (l *sharedLock) row() *sharedRow {
+	return &sharedRow{Table: l.SharedTable, Name: l.Key, Token: l.token, clock: l.options.Clock}
+}
+
+// TTL returns the remaining time-to-live. Returns 0 if the lock has expired.
+func (l *sharedLock) TTL(ctx context.Context) (time.Duration, error) {
+	sh := l.row()
+	found, err := sh.query(ctx, l.client)
+	if err != nil {
+		return 0, err
+	}
+
+	if found {
+		lockUntil, err := time.Parse(time.RFC3339Nano, sh.Until)
+		if err != nil {
+			return 0, fmt.Errorf("parse lockUnitl %s: %w", sh.Until, err)
+		}
+		if ttl := lockUntil.Sub(l.now()); ttl > 0 {
+			return ttl, nil
+		}
+	}
+
+	l.lifetime.Lose()
+	return 0, nil
+}
+
+// Refresh extends the lock with a new TTL.
+// May return ErrNotObtained if refresh is unsuccessful.
+func (l *sharedLock) Refresh(ctx context.Context, ttl time.Duration) error {
+	until := l.now().Add(ttl)
+	sh := l.row()
+	sh.Until = until.Format(time.RFC3339Nano)
+	ok, err := sh.extend(ctx, l.client)
+	if err != nil {
+		return err
+	}
+	if ok {
+		l.lifetime.Extend(until)
+		return nil
+	}
+	l.lifetime.Lose()
+	return dblock.ErrNotObtained
+}
+
+// Release manually releases the lock.
+// May return ErrLockNotHeld.
+func (l *sharedLock) Release(ctx context.Context) error {
+	if l.refresher != nil {
+		l.refresher.Stop()
+	}
+	defer l.lifetime.Lose()
+
+	ok, err := l.row().delete(ctx, l.client)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return dblock.ErrLockNotHeld
+	}
+	return nil
+}
+
+// sharedRow is a row of the holders sharing a lock.
+type sharedRow struct {
+	Table string
+	// Locks is the table of the exclusive locks which blocks the shared holders.
+	Locks string
+	Name  string
+	Token string
+	Meta  string
+	Until string
+
+	// clock tells the time in the statements.
+	clock dblock.Clock
+}
+
+func (l *sharedRow) replace(s string) stmt {
+	return bind(s, map[string]any{
+		"Table":     ident(l.Table),
+		"Locks":     ident(l.Locks),
+		"Name":      l.Name,
+		"Token":     l.Token,
+		"Meta":      metaArg(l.Meta),
+		"Until":     l.Until,
+		"Now":       now(l.clock).Format(time.RFC3339Nano),
+		"By":        Hostname,
+		"LockedPid": Pid,
+	})
+}
+
+// insert adds the holder when the exclusive lock is not held.
+func (l *sharedRow) insert(ctx context.Context, db DB) (bool, error) {
+	// clean the expired holders and the former row of the same token.
+	s := l.replace(`DELETE FROM {Table} WHERE lock_name = {Name} AND (token_value = {Token} OR lock_until <= {Now})`)
+	if _, err := db.ExecContext(ctx, s.query, s.args...); err != nil {
+		return false, fmt.Errorf("delete lock %q : %w", s.query, err)
+	}
+
+	s = l.replace(`INSERT INTO {Table} (lock_name, token_value, lock_until, locked_at, locked_by, meta_value, locked_pid) ` +
+		`SELECT {Name}, {Token}, {Until}, {Now}, {By}, {Meta}, {LockedPid} FROM (SELECT 1 AS x) t ` +
+		`WHERE NOT EXISTS (SELECT 1 FROM {Locks} WHERE lock_name = {Name} AND lock_until > {Now})`)
+	return execAffected(ctx, db, s)
+}
+
+func (l *sharedRow) count(ctx context.Context, db DB) (int, error) {
+	s := l.replace(`SELECT COUNT(*) FROM {Table} WHERE lock_name = {Name} AND lock_until > {Now}`)
+	var n int
+	if err := db.QueryRowContext(ctx, s.query, s.args...).Scan(&n); err != nil {
+		return 0, fmt.Errorf("query: %w", err)
+	}
+	return n, nil
+}
+
+func (l *sharedRow) query(ctx context.Context, db DB) (bool, error) {
+	s := l.replace(`SELECT lock_until, meta_value FROM {Table} WHERE lock_name = {Name} AND token_value = {Token}`)
+	if err := db.QueryRowContext(ctx, s.query, s.args...).Scan(&l.Until, &l.Meta); errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("query: %w", err)
+	}
+	return true, nil
+}
+
+func (l *sharedRow) extend(ctx context.Context, db DB) (bool, error) {
+	s := l.replace(`UPDATE {Table} SET lock_until = {Until} ` +
+		`WHERE lock_name = {Name} AND token_value = {Token} AND lock_until > {Now}`)
+	return execAffected(ctx, db, s)
+}
+
+func (l *sharedRow) delete(ctx context.Context, db DB) (bool, error) {
+	s := l.replace(`DELETE FROM {Table} WHERE lock_name = {Name} AND token_value = {Token} AND lock_until > {Now}`)
+	return execAffected(ctx, db, s)
+}
+
+// execAffected executes the statement, and returns whether any rows affected.
+func execAffected(ctx context.Context, db DB, s stmt) (bool, error) {
+	result, err := db.ExecContext(ctx, s.query, s.args...)
+	if err != nil {
+		return false, fmt.Errorf("exec %q : %w", s.query, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("RowsAffected: %w", err)
+	}
+
+	return rowsAffected > 0, nil
+}
@@ -0,0 +1,89 @@
+package redislock
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// luaForceRelease deletes the lock KEYS[1] with its hold count KEYS[2] and holder KEYS[4] whoever holds it,
+	// keeps the record ARGV[1] in KEYS[3] for ARGV[2] milliseconds, and notifies the waiters on the channel ARGV[3].
+	luaForceRelease = redis.NewScript(`
+if redis.call("del", KEYS[1]) == 0 then return 0 end
+
+redis.call("del", KEYS[2])
+redis.call("del", KEYS[4])
+redis.call("set", KEYS[3], ARGV[1], "PX", ARGV[2])
+redis.call("publish", ARGV[3], "released")
+return 1
+`)
+	// luaSteal sets the lock KEYS[1] whoever holds it with the hold count 1 in KEYS[3] and the holder ARGV[4..6] in KEYS[4],
+	// and returns the fencing token increased in KEYS[2].
+	luaSteal = redis.NewScript(luaSetHolder + `
+redis.call("set", KEYS[1], ARGV[1], "PX", ARGV[2])
+redis.call("set", KEYS[3], 1, "PX", ARGV[2])
+setHolder(KEYS[4], ARGV[1], tonumber(ARGV[3]), ARGV[2], ARGV[4], ARGV[5], ARGV[6])
+return redis.call("incr", KEYS[2])
+`)
+)
+
+const (
+	// adminSuffix is the suffix of the companion key to keep the record of the last force releasing.
+	adminSuffix = ":admin"
+	// adminRecordTTL is how long the record of a force releasing is kept.
+	adminRecordTTL = 24 * time.Hour
+)
+
+// ForceRelease releases the lock of the key whoever holds it,
+// the operator and the reason are recorded in the companion key <key>:admin for 24 hours.
+// May return ErrLockNotHeld if the lock is not held.
+func (c *Client) ForceRelease(ctx context.Context, key, reason string) error {
+	keys := []string{key, key + holdsSuffix, key + adminSuffix, key + holderSuffix}
+	record := dblock.AdminRecord(c.options.Clock, "force released", reason)
+	ttlVal := strconv.FormatInt(int64(adminRecordTTL/time.Millisecond), 10)
+	ok, err := luaForceRelease.Run(ctx, c.client, keys, record, ttlVal, key+releasedSuffix).Bool()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return dblock.ErrLockNotHeld
+	}
+	return nil
+}
+
+// Steal takes over the lock of the key with the given TTL whoever holds it,
+// the operator and the reason are recorded in the metadata instead of the metadata option.
+func (c *Client) Steal(ctx context.Context, key string, ttl time.Duration, reason string, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
+	opt, err := c.options.ParseOptions(optionsFns...)
+	if err != nil {
+		return nil, err
+	}
+
+	value := opt.Token + dblock.AdminRecord(opt.Clock, "stolen", reason)
+	ttlVal := strconv.FormatInt(int64(ttl/time.Millisecond), 10)
+	until := opt.Clock.Now().Add(ttl)
+	keys := []string{key, key + fenceSuffix, key + holdsSuffix, key + holderSuffix}
+	args := append([]any{value, ttlVal, len(opt.Token)}, c.holderArgs()...)
+	fence, err := luaSteal.Run(ctx, c.client, keys, args...).Uint64()
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &Lock{
+		Client:   c,
+		Key:      key,
+		value:    value,
+		tokenLen: len(opt.Token),
+		fence:    fence,
+		holds:    1,
+		lifetime: dblock.NewLifetime(opt.Clock, until),
+	}
+	if opt.AutoRefresh > 0 {
+		lock.refresher = dblock.StartRefresher(opt.Clock, lock, ttl, opt.AutoRefresh, opt.RefreshFailed)
+	}
+	return lock, nil
+}
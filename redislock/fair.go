@@ -0,0 +1,97 @@
+package redislock
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// luaObtainFair queues the token in the sorted set KEYS[4] scored by its arrival time,
+	// and keeps the waiting deadlines in the sorted set KEYS[5]. The lock KEYS[1] is granted
+	// to the head of the queue only, and its holder ARGV[6..8] is kept in KEYS[6].
+	// Returns {1, value, fence, holds} when obtained, or {0, position}.
+	luaObtainFair = redis.NewScript(luaNow + luaSetHolder + `
+local offset = tonumber(ARGV[2])
+local token = string.sub(ARGV[1], 1, offset)
+local value = redis.call("get", KEYS[1])
+local held = value and string.sub(value, 1, offset) == token
+if held and ARGV[5] == "true" then
+	local holds = redis.call("incr", KEYS[3])
+	redis.call("pexpire", KEYS[1], ARGV[3])
+	redis.call("pexpire", KEYS[3], ARGV[3])
+	redis.call("pexpire", KEYS[6], ARGV[3])
+	return {1, value, tonumber(redis.call("get", KEYS[2]) or 0), holds}
+end
+
+-- drop the waiters which stopped waiting
+local gone = redis.call("zrangebyscore", KEYS[5], "-inf", now)
+for _, waiter in ipairs(gone) do
+	redis.call("zrem", KEYS[4], waiter)
+	redis.call("zrem", KEYS[5], waiter)
+end
+
+redis.call("zadd", KEYS[4], "NX", now, token)
+local position = redis.call("zrank", KEYS[4], token)
+if held or (not value and position == 0) then
+	redis.call("zrem", KEYS[4], token)
+	redis.call("zrem", KEYS[5], token)
+	redis.call("set", KEYS[1], ARGV[1], "PX", ARGV[3])
+	redis.call("set", KEYS[3], 1, "PX", ARGV[3])
+	setHolder(KEYS[6], ARGV[1], offset, ARGV[3], ARGV[6], ARGV[7], ARGV[8])
+	return {1, ARGV[1], redis.call("incr", KEYS[2]), 1}
+end
+
+redis.call("zadd", KEYS[5], now + tonumber(ARGV[4]), token)
+local last = redis.call("zrange", KEYS[5], -1, -1, "withscores")
+redis.call("pexpireat", KEYS[4], last[2])
+redis.call("pexpireat", KEYS[5], last[2])
+return {0, position}
+`)
+	luaLeaveQueue = redis.NewScript(`
+redis.call("zrem", KEYS[1], ARGV[1])
+return redis.call("zrem", KEYS[2], ARGV[1])
+`)
+)
+
+const (
+	// queueSuffix is the suffix of the sorted set key to keep the waiters of a fair lock in arrival order.
+	queueSuffix = ":queue"
+	// waitersSuffix is the suffix of the sorted set key to keep the waiting deadlines of the waiters.
+	waitersSuffix = ":waiters"
+)
+
+// obtainFair returns nil lock with the position in the queue if not obtained.
+// The waiter is dropped from the queue if it does not come back within waitVal milliseconds.
+func (c *Client) obtainFair(ctx context.Context, key, value string, tokenLen int, ttlVal, waitVal string, reentrant bool) (*Lock, int, error) {
+	keys := []string{key, key + fenceSuffix, key + holdsSuffix, key + queueSuffix, key + waitersSuffix, key + holderSuffix}
+	args := append([]any{value, tokenLen, ttlVal, waitVal, strconv.FormatBool(reentrant)}, c.holderArgs()...)
+	res, err := luaObtainFair.Run(ctx, c.client, keys, args...).Slice()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if res[0].(int64) == 0 {
+		return nil, int(res[1].(int64)), nil
+	}
+
+	return &Lock{
+		Client:   c,
+		Key:      key,
+		value:    res[1].(string),
+		tokenLen: tokenLen,
+		fence:    uint64(res[2].(int64)),
+		holds:    int(res[3].(int64)),
+	}, 0, nil
+}
+
+// leaveQueue removes the waiter which gives up from the queue.
+func (c *Client) leaveQueue(ctx context.Context, key, token string) error {
+	err := luaLeaveQueue.Run(ctx, c.client, []string{key + queueSuffix, key + waitersSuffix}, token).Err()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	return err
+}
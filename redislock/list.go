@@ -0,0 +1,58 @@
+package redislock
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/bingoohuang/dblock"
+)
+
+// companionSuffixes are the suffixes of the keys kept besides the locks, which have no holder details.
+var companionSuffixes = []string{fenceSuffix, holdsSuffix, sharedSuffix, permitsSuffix, queueSuffix, waitersSuffix, adminSuffix, holderSuffix}
+
+// List returns the views of the held locks whose keys start with the prefix, by SCAN over the keys.
+func (c *Client) List(ctx context.Context, prefix string) ([]dblock.LockView, error) {
+	var keys []string
+	iter := c.client.Scan(ctx, 0, globEscape(prefix)+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Val())
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+
+	views := make([]dblock.LockView, 0, len(keys))
+	for _, key := range keys {
+		view, err := c.View(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		// not a lock, e.g. the sorted sets and the fence counters, or expired or released after scanned
+		if !view.Exists || view.ExpiresAt.IsZero() {
+			continue
+		}
+		// the companion strings have no holder details, while the locks named like them have
+		if hasCompanionSuffix(key) && view.AcquiredAt.IsZero() {
+			continue
+		}
+		views = append(views, view)
+	}
+	return views, nil
+}
+
+func hasCompanionSuffix(key string) bool {
+	for _, suffix := range companionSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// globEscape escapes the special characters of the glob-style pattern.
+func globEscape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`, "]", `\]`)
+	return r.Replace(s)
+}
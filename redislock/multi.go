@@ -0,0 +1,202 @@
+package redislock
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// luaObtainMulti sets all the locks KEYS[1..n] when none of them is held by other tokens,
+	// increases their fencing tokens in KEYS[n+1..2n], resets their hold counts in KEYS[2n+1..3n],
+	// and keeps the holder ARGV[5..7] in KEYS[3n+1..4n].
+	luaObtainMulti = redis.NewScript(luaSetHolder + `
+local n = tonumber(ARGV[4])
+local offset = tonumber(ARGV[2])
+for i = 1, n do
+	local value = redis.call("get", KEYS[i])
+	if value and string.sub(value, 1, offset) ~= string.sub(ARGV[1], 1, offset) then return 0 end
+end
+
+for i = 1, n do
+	redis.call("set", KEYS[i], ARGV[1], "PX", ARGV[3])
+	redis.call("incr", KEYS[n+i])
+	redis.call("del", KEYS[2*n+i])
+	setHolder(KEYS[3*n+i], ARGV[1], offset, ARGV[3], ARGV[5], ARGV[6], ARGV[7])
+end
+return 1
+`)
+	// luaRefreshMulti extends the locks KEYS[1..n] with their holders KEYS[n+1..2n].
+	luaRefreshMulti = redis.NewScript(`
+local n = #KEYS / 2
+for i = 1, n do
+	if redis.call("get", KEYS[i]) ~= ARGV[1] then return 0 end
+end
+
+for _, key in ipairs(KEYS) do redis.call("pexpire", key, ARGV[2]) end
+return 1
+`)
+	// luaReleaseMulti deletes the locks KEYS[1..n] still held with their holders KEYS[n+1..2n],
+	// notifies their waiters on the channels key..ARGV[2], and returns the number of them.
+	luaReleaseMulti = redis.NewScript(`
+local n = #KEYS / 2
+local released = 0
+for i = 1, n do
+	if redis.call("get", KEYS[i]) == ARGV[1] then
+		redis.call("del", KEYS[i])
+		redis.call("del", KEYS[n+i])
+		redis.call("publish", KEYS[i] .. ARGV[2], "released")
+		released = released + 1
+	end
+end
+return released
+`)
+	// luaPTTLMulti returns the minimum remaining time in milliseconds.
+	luaPTTLMulti = redis.NewScript(`
+local min = -3
+for _, key in ipairs(KEYS) do
+	if redis.call("get", key) ~= ARGV[1] then return -3 end
+
+	local pttl = redis.call("pttl", key)
+	if min < 0 or pttl < min then min = pttl end
+end
+return min
+`)
+)
+
+// ObtainMulti tries to obtain the locks of all the keys with the given TTL, or none of them.
+// May return ErrNotObtained if not successful.
+func (c *Client) ObtainMulti(ctx context.Context, keys []string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.MultiLock, error) {
+	return c.options.ObtainMulti(ctx, c.obtainMulti, keys, ttl, optionsFns...)
+}
+
+func (c *Client) obtainMulti(ctx context.Context, keys []string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.MultiLock, error) {
+	keys = dblock.UniqueKeys(keys)
+	if len(keys) == 0 {
+		return nil, dblock.ErrNotObtained
+	}
+
+	opt, err := c.options.ParseOptions(optionsFns...)
+	if err != nil {
+		return nil, err
+	}
+
+	scriptKeys := append([]string(nil), keys...)
+	for _, suffix := range []string{fenceSuffix, holdsSuffix, holderSuffix} {
+		for _, key := range keys {
+			scriptKeys = append(scriptKeys, key+suffix)
+		}
+	}
+
+	value := opt.Token + opt.Meta
+	ttlVal := strconv.FormatInt(int64(ttl/time.Millisecond), 10)
+	var lock *multiLock
+	err = opt.Retry(ctx, ttl, func(ctx context.Context) (bool, error) {
+		until := opt.Clock.Now().Add(ttl)
+		args := append([]any{value, len(opt.Token), ttlVal, len(keys)}, c.holderArgs()...)
+		ok, err := luaObtainMulti.Run(ctx, c.client, scriptKeys, args...).Bool()
+		if err != nil || !ok {
+			return false, err
+		}
+
+		lock = &multiLock{Client: c, keys: keys, value: value, tokenLen: len(opt.Token), lifetime: dblock.NewLifetime(opt.Clock, until)}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if opt.AutoRefresh > 0 {
+		lock.refresher = dblock.StartRefresher(opt.Clock, lock, ttl, opt.AutoRefresh, opt.RefreshFailed)
+	}
+	return lock, nil
+}
+
+// multiLock represents the obtained locks of multiple keys.
+type multiLock struct {
+	*Client
+	keys     []string
+	value    string
+	tokenLen int
+
+	lifetime  *dblock.Lifetime
+	refresher *dblock.Refresher
+}
+
+// Keys returns the keys of the locks.
+func (l *multiLock) Keys() []string { return l.keys }
+
+// withHolders returns the keys of the locks followed by the keys of their holders.
+func (l *multiLock) withHolders() []string {
+	keys := append([]string(nil), l.keys...)
+	for _, key := range l.keys {
+		keys = append(keys, key+holderSuffix)
+	}
+	return keys
+}
+
+// Token returns the token value set by the locks.
+func (l *multiLock) Token() string { return l.value[:l.tokenLen] }
+
+// Metadata returns the metadata of the locks.
+func (l *multiLock) Metadata() string { return l.value[l.tokenLen:] }
+
+// Done returns a channel that's closed when any lock is lost.
+func (l *multiLock) Done() <-chan struct{} { return l.lifetime.Done() }
+
+// Context returns a copy of parent which is cancelled when any lock is lost.
+func (l *multiLock) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	return l.lifetime.Context(parent)
+}
+
+// TTL returns the minimum remaining time-to-live. Returns 0 if any lock has expired.
+func (l *multiLock) TTL(ctx context.Context) (time.Duration, error) {
+	num, err := luaPTTLMulti.Run(ctx, l.client, l.keys, l.value).Int64()
+	if err != nil {
+		return 0, err
+	}
+
+	if num > 0 {
+		return time.Duration(num) * time.Millisecond, nil
+	}
+	l.lifetime.Lose()
+	return 0, nil
+}
+
+// Refresh extends all the locks with a new TTL.
+// May return ErrNotObtained if refresh is unsuccessful.
+func (l *multiLock) Refresh(ctx context.Context, ttl time.Duration) error {
+	ttlVal := strconv.FormatInt(int64(ttl/time.Millisecond), 10)
+	until := l.now().Add(ttl)
+	ok, err := luaRefreshMulti.Run(ctx, l.client, l.withHolders(), l.value, ttlVal).Bool()
+	if err != nil {
+		return err
+	}
+	if ok {
+		l.lifetime.Extend(until)
+		return nil
+	}
+	l.lifetime.Lose()
+	return dblock.ErrNotObtained
+}
+
+// Release manually releases all the locks.
+// May return ErrLockNotHeld if any lock is not held.
+func (l *multiLock) Release(ctx context.Context) error {
+	if l.refresher != nil {
+		l.refresher.Stop()
+	}
+	defer l.lifetime.Lose()
+
+	released, err := luaReleaseMulti.Run(ctx, l.client, l.withHolders(), l.value, releasedSuffix).Int()
+	if err != nil {
+		return err
+	}
+	if released < len(l.keys) {
+		return dblock.ErrLockNotHeld
+	}
+	return nil
+}
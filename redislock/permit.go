@@ -0,0 +1,68 @@
+package redislock
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+	"github.com/redis/go-redis/v9"
+)
+
+// luaAcquirePermit adds the token to the sorted set KEYS[1] scored by its expiring time,
+// when the number of the alive members is less than the limit ARGV[3].
+var luaAcquirePermit = redis.NewScript(luaNow + `
+redis.call("zremrangebyscore", KEYS[1], "-inf", now)
+if not redis.call("zscore", KEYS[1], ARGV[1]) and redis.call("zcard", KEYS[1]) >= tonumber(ARGV[3]) then return 0 end
+
+redis.call("zadd", KEYS[1], now + tonumber(ARGV[2]), ARGV[1])
+` + luaExpireMembers + `
+return 1
+`)
+
+// permitsSuffix is the suffix of the sorted set key to keep the permit holders of a semaphore.
+const permitsSuffix = ":permits"
+
+// AcquirePermit tries to acquire one of the limit permits of the key with the given TTL,
+// the expired permits are reclaimed automatically.
+// May return ErrNotObtained if not successful.
+func (c *Client) AcquirePermit(ctx context.Context, key string, limit int, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
+	acquire := func(ctx context.Context, key string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
+		return c.acquirePermit(ctx, key, limit, ttl, optionsFns...)
+	}
+	return c.options.Obtain(ctx, acquire, key, ttl, optionsFns...)
+}
+
+func (c *Client) acquirePermit(ctx context.Context, key string, limit int, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
+	opt, err := c.options.ParseOptions(optionsFns...)
+	if err != nil {
+		return nil, err
+	}
+
+	ttlVal := strconv.FormatInt(int64(ttl/time.Millisecond), 10)
+	var lock *memberLock
+	err = opt.Retry(ctx, ttl, func(ctx context.Context) (bool, error) {
+		until := opt.Clock.Now().Add(ttl)
+		ok, err := luaAcquirePermit.Run(ctx, c.client, []string{key + permitsSuffix}, opt.Token, ttlVal, limit).Bool()
+		if err != nil || !ok {
+			return false, err
+		}
+
+		lock = &memberLock{
+			Client:   c,
+			Key:      key + permitsSuffix,
+			token:    opt.Token,
+			metadata: opt.Meta,
+			lifetime: dblock.NewLifetime(opt.Clock, until),
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if opt.AutoRefresh > 0 {
+		lock.refresher = dblock.StartRefresher(opt.Clock, lock, ttl, opt.AutoRefresh, opt.RefreshFailed)
+	}
+	return lock, nil
+}
@@ -10,19 +10,98 @@ import (
 	"github.com/redis/go-redis/v9"
 )
 
+// luaSetHolder defines setHolder, which keeps the holder details of the lock value in the hash key with the same TTL.
+const luaSetHolder = `
+local function setHolder(key, value, tokenLen, ttl, host, pid, at)
+	redis.call("del", key)
+	redis.call("hset", key, "token", string.sub(value, 1, tokenLen), "meta", string.sub(value, tokenLen + 1),
+		"host", host, "pid", pid, "at", at)
+	redis.call("pexpire", key, ttl)
+end
+`
+
 var (
-	luaRefresh = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pexpire", KEYS[1], ARGV[2]) else return 0 end`)
-	luaRelease = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`)
+	luaRefresh = redis.NewScript(`
+if redis.call("get", KEYS[1]) ~= ARGV[1] then return 0 end
+
+redis.call("pexpire", KEYS[2], ARGV[2])
+redis.call("pexpire", KEYS[3], ARGV[2])
+return redis.call("pexpire", KEYS[1], ARGV[2])
+`)
+	// luaRelease decreases the hold count in KEYS[2], and deletes the lock with its holder KEYS[3] when no holds left,
+	// then notifies the waiters on the channel ARGV[2].
+	luaRelease = redis.NewScript(`
+if redis.call("get", KEYS[1]) ~= ARGV[1] then return 0 end
+if redis.call("decr", KEYS[2]) > 0 then return 1 end
+
+redis.call("del", KEYS[2])
+redis.call("del", KEYS[3])
+redis.call("del", KEYS[1])
+redis.call("publish", ARGV[2], "released")
+return 1
+`)
 	// PTTL returns the amount of remaining time in milliseconds.
-	luaPTTL   = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pttl", KEYS[1]) else return -3 end`)
-	luaObtain = redis.NewScript(`
-if redis.call("set", KEYS[1], ARGV[1], "NX", "PX", ARGV[3]) then return redis.status_reply("OK") end
+	luaPTTL = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("pttl", KEYS[1]) else return -3 end`)
+	// luaObtain returns {value, fencing token increased in KEYS[2], hold count reset to 1 in KEYS[3]} when obtained,
+	// and keeps the holder ARGV[4..6] in KEYS[4].
+	luaObtain = redis.NewScript(luaSetHolder + `
+if not redis.call("set", KEYS[1], ARGV[1], "NX", "PX", ARGV[3]) then
+	local offset = tonumber(ARGV[2])
+	if redis.call("getrange", KEYS[1], 0, offset-1) ~= string.sub(ARGV[1], 1, offset) then return nil end
+	redis.call("set", KEYS[1], ARGV[1], "PX", ARGV[3])
+end
 
+redis.call("set", KEYS[3], 1, "PX", ARGV[3])
+setHolder(KEYS[4], ARGV[1], tonumber(ARGV[2]), ARGV[3], ARGV[4], ARGV[5], ARGV[6])
+return {ARGV[1], redis.call("incr", KEYS[2]), 1}
+`)
+	// luaObtainReentrant increases the hold count in KEYS[3] when the lock is held by the same token.
+	luaObtainReentrant = redis.NewScript(luaSetHolder + `
 local offset = tonumber(ARGV[2])
-if redis.call("getrange", KEYS[1], 0, offset-1) == string.sub(ARGV[1], 1, offset) then return redis.call("set", KEYS[1], ARGV[1], "PX", ARGV[3]) end
+local value = redis.call("get", KEYS[1])
+if value and string.sub(value, 1, offset) == string.sub(ARGV[1], 1, offset) then
+	local holds = redis.call("incr", KEYS[3])
+	redis.call("pexpire", KEYS[1], ARGV[3])
+	redis.call("pexpire", KEYS[3], ARGV[3])
+	redis.call("pexpire", KEYS[4], ARGV[3])
+	return {value, tonumber(redis.call("get", KEYS[2]) or 0), holds}
+end
+
+if redis.call("set", KEYS[1], ARGV[1], "NX", "PX", ARGV[3]) then
+	redis.call("set", KEYS[3], 1, "PX", ARGV[3])
+	setHolder(KEYS[4], ARGV[1], tonumber(ARGV[2]), ARGV[3], ARGV[4], ARGV[5], ARGV[6])
+	return {ARGV[1], redis.call("incr", KEYS[2]), 1}
+end
+`)
+	// luaView returns {value, pttl, token, meta, host, pid, acquired at} of the lock KEYS[1] with its holder KEYS[2],
+	// or nil if KEYS[1] is not a string key.
+	luaView = redis.NewScript(`
+if redis.call("type", KEYS[1]).ok ~= "string" then return nil end
+
+local value = redis.call("get", KEYS[1])
+if not value then return nil end
+
+local h = redis.call("hmget", KEYS[2], "token", "meta", "host", "pid", "at")
+return {value, redis.call("pttl", KEYS[1]), h[1] or "", h[2] or "", h[3] or "", h[4] or "", h[5] or ""}
 `)
 )
 
+const (
+	// fenceSuffix is the suffix of the companion key to keep the fencing token of a lock.
+	fenceSuffix = ":fence"
+	// holdsSuffix is the suffix of the companion key to keep the hold count of a reentrant lock.
+	holdsSuffix = ":holds"
+	// holderSuffix is the suffix of the companion hash key to keep the holder details of a lock.
+	holderSuffix = ":holder"
+	// releasedSuffix is the suffix of the pub/sub channel to notify the waiters when a lock is released.
+	releasedSuffix = ":released"
+)
+
+// holderArgs returns the holder details passed to setHolder: host, pid and acquired time in unix milliseconds.
+func (c *Client) holderArgs() []any {
+	return []any{dblock.Hostname, dblock.Pid, c.now().UnixMilli()}
+}
+
 // Obtain is a short-cut for New(...).Obtain(...).
 func Obtain(ctx context.Context, client *redis.Client, key string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
 	return New(client).Obtain(ctx, key, ttl, optionsFns...)
@@ -30,98 +109,122 @@ func Obtain(ctx context.Context, client *redis.Client, key string, ttl time.Dura
 
 // Client wraps a redis client.
 type Client struct {
-	client *redis.Client
+	client  *redis.Client
+	options dblock.ClientOptions
 }
 
 // New creates a new Client instance with a custom namespace.
-func New(client *redis.Client) *Client {
-	return &Client{client: client}
+func New(client *redis.Client, optionsFns ...dblock.ClientOptionsFn) *Client {
+	return &Client{client: client, options: dblock.ParseClientOptions(optionsFns...)}
 }
 
-type lockView struct {
-	TokenMeta string
-	time.Duration
-}
-
-func (l lockView) GetToken() string    { return l.TokenMeta }
-func (l lockView) GetMetadata() string { return l.GetMetadata() }
-func (l lockView) GetUntil() string    { return l.Duration.String() }
-func (l lockView) String() string {
-	return "{TokenMeta: " + l.TokenMeta + " Duration: " + l.Duration.String() + "}"
-}
+// now returns the current time of the clock of the client, the expiry of the keys is still kept by redis.
+func (c *Client) now() time.Time { return c.options.Clock.Now() }
 
+// View returns the present state of the lock, the holder details are kept in the companion hash <key>:holder.
 func (c *Client) View(ctx context.Context, key string) (dblock.LockView, error) {
-	result, err := c.client.Get(ctx, key).Result()
-	if err != nil {
-		if errors.Is(err, redis.Nil) {
-			return &lockView{}, nil
-		}
-		return nil, err
+	view := dblock.LockView{Key: key}
+	res, err := luaView.Run(ctx, c.client, []string{key, key + holderSuffix}).Slice()
+	if errors.Is(err, redis.Nil) {
+		return view, nil
+	} else if err != nil {
+		return view, err
 	}
 
-	duration, err := c.client.TTL(ctx, key).Result()
-	if err != nil {
-		return nil, err
+	value := res[0].(string)
+	view.Exists = true
+	view.Token = value
+	if pttl := res[1].(int64); pttl > 0 {
+		view.ExpiresAt = c.now().Add(time.Duration(pttl) * time.Millisecond)
 	}
 
-	return &lockView{TokenMeta: result, Duration: duration}, nil
+	// the holder details are missing for the locks obtained by the former versions.
+	if token, meta := res[2].(string), res[3].(string); token != "" && token+meta == value {
+		view.Token, view.Metadata = token, meta
+		view.Holder.Host = res[4].(string)
+		view.Holder.PID, _ = strconv.Atoi(res[5].(string))
+		if at, err := strconv.ParseInt(res[6].(string), 10, 64); err == nil {
+			view.AcquiredAt = time.UnixMilli(at)
+		}
+	}
+	return view, nil
 }
 
 // Obtain tries to obtain a new lock using a key with the given TTL.
 // May return ErrNotObtained if not successful.
 func (c *Client) Obtain(ctx context.Context, key string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
-	opt := &dblock.Options{}
-	for _, f := range optionsFns {
-		f(opt)
-	}
+	return c.options.Obtain(ctx, c.obtainLock, key, ttl, optionsFns...)
+}
 
-	token := opt.Token
+func (c *Client) obtainLock(ctx context.Context, key string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
+	opt, err := c.options.ParseOptions(optionsFns...)
+	if err != nil {
+		return nil, err
+	}
+	return c.obtainWith(ctx, key, ttl, opt)
+}
 
-	// Create a random token
-	if token == "" {
+func (c *Client) obtainWith(ctx context.Context, key string, ttl time.Duration, opt *dblock.Options) (dblock.Lock, error) {
+	var lock *Lock
+	attempt, leave := c.attempts(key, ttl, opt)
+	wake := func(ctx context.Context) <-chan struct{} { return c.released(ctx, key) }
+	err := opt.RetryWake(ctx, ttl, wake, func(ctx context.Context) (bool, error) {
 		var err error
-		if token, err = dblock.RandomToken(); err != nil {
-			return nil, err
-		}
+		lock, err = attempt(ctx)
+		return lock != nil, err
+	})
+	if err != nil {
+		leave()
+		return nil, err
 	}
 
-	value := token + opt.Meta
-	ttlVal := strconv.FormatInt(int64(ttl/time.Millisecond), 10)
-	retry := opt.GetRetryStrategy()
-
-	// make sure we don't retry forever
-	if _, ok := ctx.Deadline(); !ok {
-		var cancel context.CancelFunc
-		ctx, cancel = context.WithDeadline(ctx, time.Now().Add(ttl))
-		defer cancel()
+	if opt.AutoRefresh > 0 {
+		lock.refresher = dblock.StartRefresher(opt.Clock, lock, ttl, opt.AutoRefresh, opt.RefreshFailed)
 	}
+	return lock, nil
+}
 
-	var ticker *time.Ticker
-	for {
-		if ok, err := c.obtain(ctx, key, value, len(token), ttlVal); err != nil {
-			return nil, err
-		} else if ok {
-			return &Lock{Client: c, Key: key, value: value, tokenLen: len(token)}, nil
-		}
-
-		backoff := retry.NextBackoff()
-		if backoff < 1 {
-			return nil, dblock.ErrNotObtained
-		}
-
-		if ticker == nil {
-			ticker = time.NewTicker(backoff)
-			defer ticker.Stop()
+// attempts returns the attempt to obtain the lock with the options, which returns nil lock if not obtained,
+// and the leave to call when giving up. The waiter of a fair lock is kept in the queue between the attempts.
+func (c *Client) attempts(key string, ttl time.Duration, opt *dblock.Options) (attempt func(ctx context.Context) (*Lock, error), leave func()) {
+	value := opt.Token + opt.Meta
+	ttlVal := strconv.FormatInt(int64(ttl/time.Millisecond), 10)
+	position := -1
+	var waiterTTL func() time.Duration
+	if opt.Fair {
+		waiterTTL = opt.WaiterTTL(ttl)
+	}
+	attempt = func(ctx context.Context) (*Lock, error) {
+		until := opt.Clock.Now().Add(ttl)
+		var lock *Lock
+		var err error
+		if opt.Fair {
+			// the waiter is kept in the queue until a while after its next attempt is due
+			var pos int
+			waitVal := strconv.FormatInt(int64(waiterTTL()/time.Millisecond), 10)
+			lock, pos, err = c.obtainFair(ctx, key, value, len(opt.Token), ttlVal, waitVal, opt.Reentrant)
+			if err == nil && lock == nil && pos != position {
+				position = pos
+				if opt.QueuePosition != nil {
+					opt.QueuePosition(pos)
+				}
+			}
 		} else {
-			ticker.Reset(backoff)
+			lock, err = c.obtain(ctx, key, value, len(opt.Token), ttlVal, opt.Reentrant)
+		}
+		if err != nil || lock == nil {
+			return nil, err
 		}
 
-		select {
-		case <-ctx.Done():
-			return nil, ctx.Err()
-		case <-ticker.C:
+		lock.lifetime = dblock.NewLifetime(opt.Clock, until)
+		return lock, nil
+	}
+	leave = func() {
+		if opt.Fair {
+			_ = c.leaveQueue(context.Background(), key, opt.Token)
 		}
 	}
+	return attempt, leave
 }
 
 // Lock represents an obtained, distributed lock.
@@ -130,6 +233,11 @@ type Lock struct {
 	Key      string
 	value    string
 	tokenLen int
+	fence    uint64
+	holds    int
+
+	lifetime  *dblock.Lifetime
+	refresher *dblock.Refresher
 }
 
 // Token returns the token value set by the lock.
@@ -142,10 +250,26 @@ func (l *Lock) Metadata() string {
 	return l.value[l.tokenLen:]
 }
 
+// Fence returns the fencing token, which increases on every successful Obtain of the key.
+func (l *Lock) Fence() uint64 { return l.fence }
+
+// Holds returns the hold count of the key when the lock is obtained, it is greater than 1
+// when a reentrant lock is obtained again by its owner.
+func (l *Lock) Holds() int { return l.holds }
+
+// Done returns a channel that's closed when the lock is lost.
+func (l *Lock) Done() <-chan struct{} { return l.lifetime.Done() }
+
+// Context returns a copy of parent which is cancelled when the lock is lost.
+func (l *Lock) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	return l.lifetime.Context(parent)
+}
+
 // TTL returns the remaining time-to-live. Returns 0 if the lock has expired.
 func (l *Lock) TTL(ctx context.Context) (time.Duration, error) {
 	res, err := luaPTTL.Run(ctx, l.client, []string{l.Key}, l.value).Result()
 	if errors.Is(err, redis.Nil) {
+		l.lifetime.Lose()
 		return 0, nil
 	}
 	if err != nil {
@@ -155,6 +279,7 @@ func (l *Lock) TTL(ctx context.Context) (time.Duration, error) {
 	if num := res.(int64); num > 0 {
 		return time.Duration(num) * time.Millisecond, nil
 	}
+	l.lifetime.Lose()
 	return 0, nil
 }
 
@@ -162,20 +287,28 @@ func (l *Lock) TTL(ctx context.Context) (time.Duration, error) {
 // May return ErrNotObtained if refresh is unsuccessful.
 func (l *Lock) Refresh(ctx context.Context, ttl time.Duration) error {
 	ttlVal := strconv.FormatInt(int64(ttl/time.Millisecond), 10)
-	status, err := luaRefresh.Run(ctx, l.client, []string{l.Key}, l.value, ttlVal).Result()
+	until := l.now().Add(ttl)
+	status, err := luaRefresh.Run(ctx, l.client, []string{l.Key, l.Key + holdsSuffix, l.Key + holderSuffix}, l.value, ttlVal).Result()
 	if err != nil {
 		return err
 	}
 	if status == int64(1) {
+		l.lifetime.Extend(until)
 		return nil
 	}
+	l.lifetime.Lose()
 	return dblock.ErrNotObtained
 }
 
 // Release manually releases the lock.
 // May return ErrLockNotHeld.
 func (l *Lock) Release(ctx context.Context) error {
-	res, err := luaRelease.Run(ctx, l.client, []string{l.Key}, l.value).Result()
+	if l.refresher != nil {
+		l.refresher.Stop()
+	}
+	defer l.lifetime.Lose()
+
+	res, err := luaRelease.Run(ctx, l.client, []string{l.Key, l.Key + holdsSuffix, l.Key + holderSuffix}, l.value, l.Key+releasedSuffix).Result()
 	if errors.Is(err, redis.Nil) {
 		return dblock.ErrLockNotHeld
 	}
@@ -189,12 +322,63 @@ func (l *Lock) Release(ctx context.Context) error {
 	return nil
 }
 
-func (c *Client) obtain(ctx context.Context, key, value string, tokenLen int, ttlVal string) (bool, error) {
-	_, err := luaObtain.Run(ctx, c.client, []string{key}, value, tokenLen, ttlVal).Result()
+// obtain returns nil lock if not obtained.
+func (c *Client) obtain(ctx context.Context, key, value string, tokenLen int, ttlVal string, reentrant bool) (*Lock, error) {
+	script := luaObtain
+	if reentrant {
+		script = luaObtainReentrant
+	}
+
+	keys := []string{key, key + fenceSuffix, key + holdsSuffix, key + holderSuffix}
+	args := append([]any{value, tokenLen, ttlVal}, c.holderArgs()...)
+	res, err := script.Run(ctx, c.client, keys, args...).Slice()
 	if errors.Is(err, redis.Nil) {
-		return false, nil
+		return nil, nil
 	} else if err != nil {
-		return false, err
+		return nil, err
 	}
-	return true, nil
+
+	return &Lock{
+		Client:   c,
+		Key:      key,
+		value:    res[0].(string),
+		tokenLen: tokenLen,
+		fence:    uint64(res[1].(int64)),
+		holds:    int(res[2].(int64)),
+	}, nil
+}
+
+// released subscribes the channel notified when the lock of the key is released,
+// the subscription is closed when ctx is done. Returns nil if failed to subscribe.
+func (c *Client) released(ctx context.Context, key string) <-chan struct{} {
+	pubsub := c.client.Subscribe(ctx, key+releasedSuffix)
+	if _, err := pubsub.Receive(ctx); err != nil {
+		_ = pubsub.Close()
+		if c.options.Logger != nil {
+			c.options.Logger.DebugContext(ctx, "dblock: subscribe failed", "key", key, "error", err)
+		}
+		return nil
+	}
+
+	wake := make(chan struct{}, 1)
+	go func() {
+		defer pubsub.Close()
+
+		ch := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case _, ok := <-ch:
+				if !ok {
+					return
+				}
+				select {
+				case wake <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return wake
 }
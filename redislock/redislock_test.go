@@ -4,17 +4,24 @@ import (
 	"context"
 	"errors"
 	"math/rand"
+	"os"
+	"reflect"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/bingoohuang/dblock"
+	"github.com/bingoohuang/dblock/clocktest"
 	"github.com/bingoohuang/dblock/redislock"
 	"github.com/redis/go-redis/v9"
 )
 
-const lockKey = "__bsm_redislock_unit_test__"
+const (
+	lockKey  = "__bsm_redislock_unit_test__"
+	otherKey = "__bsm_redislock_unit_test_other__"
+)
 
 var redisOpts = &redis.Options{
 	Network: "tcp",
@@ -74,6 +81,40 @@ func TestObtain(t *testing.T) {
 	}
 }
 
+func TestClient_View(t *testing.T) {
+	ctx := context.Background()
+	rc := redis.NewClient(redisOpts)
+	defer teardown(t, rc)
+
+	client := redislock.New(rc)
+	if view, err := client.View(ctx, lockKey); err != nil || view.Exists {
+		t.Fatalf("expected not exists, got %v, %v", view, err)
+	}
+
+	lock, err := client.Obtain(ctx, lockKey, time.Hour, dblock.WithToken("foo"), dblock.WithMeta("bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release(ctx)
+
+	view, err := client.View(ctx, lockKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !view.Exists || view.Key != lockKey || view.Token != "foo" || view.Metadata != "bar" {
+		t.Fatalf("unexpected view %v", view)
+	}
+	if exp, got := os.Getpid(), view.Holder.PID; exp != got {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	if ttl := time.Until(view.ExpiresAt); ttl < 59*time.Minute || ttl > time.Hour {
+		t.Fatalf("expected expires in ~%v, got %v", time.Hour, ttl)
+	}
+	if elapsed := time.Since(view.AcquiredAt); elapsed < 0 || elapsed > time.Minute {
+		t.Fatalf("expected acquired just now, got %v", view.AcquiredAt)
+	}
+}
+
 func TestObtain_metadata(t *testing.T) {
 	ctx := context.Background()
 	rc := redis.NewClient(redisOpts)
@@ -91,6 +132,34 @@ func TestObtain_metadata(t *testing.T) {
 	}
 }
 
+func TestObtain_jsonMetadata(t *testing.T) {
+	ctx := context.Background()
+	rc := redis.NewClient(redisOpts)
+	defer teardown(t, rc)
+
+	client := redislock.New(rc)
+	meta := map[string]string{"job": "42", "trace": "abc"}
+	lock, err := client.Obtain(ctx, lockKey, time.Hour, dblock.WithMetadata(meta))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release(ctx)
+
+	var got map[string]string
+	if err := dblock.DecodeMetadata(lock, &got); err != nil || !reflect.DeepEqual(meta, got) {
+		t.Fatalf("expected %v, got %v, %v", meta, got, err)
+	}
+
+	view, err := client.View(ctx, lockKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	if err := view.DecodeMetadata(&got); err != nil || !reflect.DeepEqual(meta, got) {
+		t.Fatalf("expected %v, got %v, %v", meta, got, err)
+	}
+}
+
 func TestObtain_custom_token(t *testing.T) {
 	ctx := context.Background()
 	rc := redis.NewClient(redisOpts)
@@ -131,6 +200,538 @@ func TestObtain_custom_token(t *testing.T) {
 	}
 }
 
+func TestObtain_fence(t *testing.T) {
+	ctx := context.Background()
+	rc := redis.NewClient(redisOpts)
+	defer teardown(t, rc)
+
+	lock1 := quickObtain(t, rc, time.Hour)
+	if err := lock1.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	lock2 := quickObtain(t, rc, time.Hour)
+	defer lock2.Release(ctx)
+
+	if lock2.Fence() <= lock1.Fence() {
+		t.Fatalf("expected fence %d to be greater than %d", lock2.Fence(), lock1.Fence())
+	}
+}
+
+func TestObtain_reentrant(t *testing.T) {
+	ctx := context.Background()
+	rc := redis.NewClient(redisOpts)
+	defer teardown(t, rc)
+
+	lock1, err := redislock.Obtain(ctx, rc, lockKey, time.Hour, dblock.WithToken("foo"), dblock.WithReentrant())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock2, err := redislock.Obtain(ctx, rc, lockKey, time.Hour, dblock.WithToken("foo"), dblock.WithReentrant())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exp, got := 2, dblock.Holds(lock2); exp != got {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+
+	// the first release keeps the lock held for the other hold
+	if err := lock2.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := redislock.Obtain(ctx, rc, lockKey, time.Hour); !errors.Is(err, dblock.ErrNotObtained) {
+		t.Fatalf("expected %v, got %v", dblock.ErrNotObtained, err)
+	}
+
+	if err := lock1.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	lock3 := quickObtain(t, rc, time.Hour)
+	defer lock3.Release(ctx)
+}
+
+func TestObtain_reentrant_afterPlain(t *testing.T) {
+	ctx := context.Background()
+	rc := redis.NewClient(redisOpts)
+	defer teardown(t, rc)
+
+	// the plain obtain counts as the first hold
+	lock1, err := redislock.Obtain(ctx, rc, lockKey, time.Hour, dblock.WithToken("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock2, err := redislock.Obtain(ctx, rc, lockKey, time.Hour, dblock.WithToken("foo"), dblock.WithReentrant())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, got := 2, dblock.Holds(lock2); exp != got {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+
+	if err := lock2.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := redislock.Obtain(ctx, rc, lockKey, time.Hour); !errors.Is(err, dblock.ErrNotObtained) {
+		t.Fatalf("expected %v, got %v", dblock.ErrNotObtained, err)
+	}
+	if err := lock1.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestObtain_fair(t *testing.T) {
+	ctx := context.Background()
+	rc := redis.NewClient(redisOpts)
+	defer teardown(t, rc)
+
+	client := redislock.New(rc)
+	holder, err := client.Obtain(ctx, lockKey, time.Hour, dblock.WithFair())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	positions := make(chan int, 10)
+	order := make(chan string, 2)
+	var wg sync.WaitGroup
+	for _, name := range []string{"first", "second"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+
+			lock, err := client.Obtain(ctx, lockKey, time.Second, dblock.WithFair(),
+				dblock.WithRetryStrategy(dblock.LinearBackoff(5*time.Millisecond)),
+				dblock.WithQueuePosition(func(position int) {
+					if name == "second" {
+						positions <- position
+					}
+				}))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			order <- name
+			time.Sleep(20 * time.Millisecond)
+			_ = lock.Release(ctx)
+		}(name)
+		// queue the waiters in order
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// the second waiter is behind the first one
+	if exp, got := 1, <-positions; exp != got {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	if err := holder.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	wg.Wait()
+	if exp, got := "first", <-order; exp != got {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+}
+
+func TestObtain_fair_waiterTTL(t *testing.T) {
+	ctx := context.Background()
+	rc := redis.NewClient(redisOpts)
+	defer teardown(t, rc)
+
+	client := redislock.New(rc)
+	holder, err := client.Obtain(ctx, lockKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer holder.Release(ctx)
+
+	attempts := make(chan int, 10)
+	waitCtx, cancel := context.WithCancel(dblock.WithRetryTrace(ctx, &dblock.RetryTrace{
+		AttemptDone: func(attempt int, _ bool, _ error) { attempts <- attempt },
+	}))
+	done := make(chan struct{})
+	defer func() {
+		cancel()
+		<-done
+	}()
+	go func() {
+		defer close(done)
+		_, _ = client.Obtain(waitCtx, lockKey, time.Hour, dblock.WithFair(), dblock.WithToken("waiter"), dblock.WithBlocking(),
+			dblock.WithRetryStrategy(dblock.LinearBackoff(20*time.Millisecond)))
+	}()
+	// the attempt after the first backoff
+	for <-attempts < 2 {
+	}
+
+	// the waiter is kept in the queue for 3 times its backoff after an attempt, not the hour of the ttl
+	deadline, err := rc.ZScore(ctx, lockKey+":waiters", "waiter").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now, err := rc.Time(ctx).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait := time.Duration(deadline)*time.Millisecond - time.Duration(now.UnixMilli())*time.Millisecond; wait > time.Second {
+		t.Fatalf("expected the waiter kept for 60ms, got %v", wait)
+	}
+}
+
+func TestClient_ObtainShared(t *testing.T) {
+	ctx := context.Background()
+	rc := redis.NewClient(redisOpts)
+	defer teardown(t, rc)
+
+	client := redislock.New(rc)
+
+	// many shared holders at once
+	shared1, err := client.ObtainShared(ctx, lockKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared2, err := client.ObtainShared(ctx, lockKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertTTL(t, shared2, time.Hour)
+
+	// the exclusive lock waits for the shared holders
+	_, err = client.ObtainExclusive(ctx, lockKey, time.Hour, dblock.WithRetryStrategy(
+		dblock.LimitRetry(dblock.LinearBackoff(5*time.Millisecond), 2),
+	))
+	if exp, got := dblock.ErrNotObtained, err; !errors.Is(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+
+	for _, lock := range []dblock.Lock{shared1, shared2} {
+		if err := lock.Release(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exclusive, err := client.ObtainExclusive(ctx, lockKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exclusive.Release(ctx)
+
+	// no shared holders while the exclusive lock is held
+	_, err = client.ObtainShared(ctx, lockKey, time.Hour)
+	if exp, got := dblock.ErrNotObtained, err; !errors.Is(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+}
+
+func TestClient_ObtainExclusive_waitReaders(t *testing.T) {
+	ctx := context.Background()
+	rc := redis.NewClient(redisOpts)
+	defer teardown(t, rc)
+
+	client := redislock.New(rc)
+	if _, err := client.ObtainShared(ctx, lockKey, 150*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	// the writer waits for the reader longer than its own ttl, and keeps the lock meanwhile
+	exclusive, err := client.ObtainExclusive(ctx, lockKey, 50*time.Millisecond,
+		dblock.WithRetryStrategy(dblock.LinearBackoff(10*time.Millisecond)), dblock.WithWaitTimeout(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exclusive.Release(ctx)
+
+	// not lost and obtained again while waiting
+	if exclusive.Fence() != 1 {
+		t.Fatalf("expected the lock obtained once, got fence %d", exclusive.Fence())
+	}
+	if ttl, err := exclusive.TTL(ctx); err != nil || ttl <= 0 {
+		t.Fatalf("expected the lock held, got %v, %v", ttl, err)
+	}
+	if _, err := client.Obtain(ctx, lockKey, time.Hour); !errors.Is(err, dblock.ErrNotObtained) {
+		t.Fatalf("expected %v, got %v", dblock.ErrNotObtained, err)
+	}
+	if _, err := client.ObtainShared(ctx, lockKey, time.Hour); !errors.Is(err, dblock.ErrNotObtained) {
+		t.Fatalf("expected %v, got %v", dblock.ErrNotObtained, err)
+	}
+}
+
+func TestClient_ObtainExclusive_fair(t *testing.T) {
+	ctx := context.Background()
+	rc := redis.NewClient(redisOpts)
+	defer teardown(t, rc)
+
+	client := redislock.New(rc)
+	holder, err := client.Obtain(ctx, lockKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the exclusive waiter is at the head of the queue
+	attempts := make(chan int, 10)
+	waitCtx := dblock.WithRetryTrace(ctx, &dblock.RetryTrace{
+		AttemptDone: func(attempt int, _ bool, _ error) { attempts <- attempt },
+	})
+	obtained := make(chan error, 1)
+	go func() {
+		lock, err := client.ObtainExclusive(waitCtx, lockKey, time.Hour, dblock.WithFair(),
+			dblock.WithRetryStrategy(dblock.LinearBackoff(50*time.Millisecond)), dblock.WithWaitTimeout(time.Minute))
+		if err == nil {
+			err = lock.Release(ctx)
+		}
+		obtained <- err
+	}()
+	for <-attempts < 2 {
+	}
+
+	// and kept in the queue between its attempts, the later waiter is queued behind
+	var position int
+	_, err = client.Obtain(ctx, lockKey, time.Hour, dblock.WithFair(),
+		dblock.WithQueuePosition(func(p int) { position = p }))
+	if !errors.Is(err, dblock.ErrNotObtained) {
+		t.Fatalf("expected %v, got %v", dblock.ErrNotObtained, err)
+	}
+	if position != 1 {
+		t.Fatalf("expected 1 waiter ahead, got %d", position)
+	}
+
+	if err := holder.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-obtained; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClient_AcquirePermit(t *testing.T) {
+	ctx := context.Background()
+	rc := redis.NewClient(redisOpts)
+	defer teardown(t, rc)
+
+	client := redislock.New(rc)
+
+	permit1, err := client.AcquirePermit(ctx, lockKey, 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	permit2, err := client.AcquirePermit(ctx, lockKey, 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer permit2.Release(ctx)
+
+	// no more permits
+	if _, err := client.AcquirePermit(ctx, lockKey, 2, time.Hour); !errors.Is(err, dblock.ErrNotObtained) {
+		t.Fatalf("expected %v, got %v", dblock.ErrNotObtained, err)
+	}
+
+	if err := permit1.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	permit3, err := client.AcquirePermit(ctx, lockKey, 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer permit3.Release(ctx)
+}
+
+func TestClient_AcquirePermit_expired(t *testing.T) {
+	ctx := context.Background()
+	rc := redis.NewClient(redisOpts)
+	defer teardown(t, rc)
+
+	client := redislock.New(rc)
+	if _, err := client.AcquirePermit(ctx, lockKey, 1, 5*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	// the expired permit is reclaimed
+	time.Sleep(10 * time.Millisecond)
+	permit, err := client.AcquirePermit(ctx, lockKey, 1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer permit.Release(ctx)
+}
+
+func TestClient_ObtainMulti(t *testing.T) {
+	ctx := context.Background()
+	rc := redis.NewClient(redisOpts)
+	defer teardown(t, rc)
+
+	client := redislock.New(rc)
+
+	// one of the keys is held by others
+	other, err := client.Obtain(ctx, otherKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.ObtainMulti(ctx, []string{lockKey, otherKey}, time.Hour); !errors.Is(err, dblock.ErrNotObtained) {
+		t.Fatalf("expected %v, got %v", dblock.ErrNotObtained, err)
+	}
+	// none of them is obtained
+	if n, err := rc.Exists(ctx, lockKey).Result(); err != nil || n != 0 {
+		t.Fatalf("expected %s not set, got %d, %v", lockKey, n, err)
+	}
+
+	if err := other.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	multi, err := client.ObtainMulti(ctx, []string{otherKey, lockKey, otherKey}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, got := []string{lockKey, otherKey}, multi.Keys(); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	if err := multi.Refresh(ctx, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, err := multi.TTL(ctx); err != nil || ttl <= 0 || ttl > time.Minute {
+		t.Fatalf("expected ~%v, got %v, %v", time.Minute, ttl, err)
+	}
+	if err := multi.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := multi.Release(ctx); !errors.Is(err, dblock.ErrLockNotHeld) {
+		t.Fatalf("expected %v, got %v", dblock.ErrLockNotHeld, err)
+	}
+}
+
+func TestObtain_wakeup(t *testing.T) {
+	ctx := context.Background()
+	rc := redis.NewClient(redisOpts)
+	defer teardown(t, rc)
+
+	holder := quickObtain(t, rc, time.Hour)
+	time.AfterFunc(50*time.Millisecond, func() { _ = holder.Release(ctx) })
+
+	// woken up by the releasing, rather than the retrying ticker
+	start := time.Now()
+	lock, err := redislock.Obtain(ctx, rc, lockKey, time.Hour, dblock.WithRetryStrategy(dblock.LinearBackoff(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release(ctx)
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected woken up at once, got %v", elapsed)
+	}
+}
+
+func TestClient_List(t *testing.T) {
+	ctx := context.Background()
+	rc := redis.NewClient(redisOpts)
+	defer teardown(t, rc)
+
+	client := redislock.New(rc)
+	// the lock named like a companion key is listed too
+	for _, key := range []string{otherKey, lockKey, lockKey + ":queue"} {
+		lock, err := client.Obtain(ctx, key, time.Hour, dblock.WithReentrant())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer lock.Release(ctx)
+	}
+
+	views, err := client.List(ctx, "__bsm_redislock_unit_test_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the companion keys are not listed
+	var keys []string
+	for _, view := range views {
+		keys = append(keys, view.Key)
+	}
+	if exp := []string{lockKey, lockKey + ":queue", otherKey}; !reflect.DeepEqual(exp, keys) {
+		t.Fatalf("expected %v, got %v", exp, keys)
+	}
+
+	if views, err := client.List(ctx, otherKey); err != nil || len(views) != 1 {
+		t.Fatalf("expected 1 view, got %v, %v", views, err)
+	}
+}
+
+func TestClient_ForceRelease(t *testing.T) {
+	ctx := context.Background()
+	rc := redis.NewClient(redisOpts)
+	defer teardown(t, rc)
+
+	clock := clocktest.NewFakeClock(time.Now().Add(time.Hour))
+	client := redislock.New(rc, dblock.WithClock(clock))
+	lock := quickObtain(t, rc, time.Hour)
+	if err := client.ForceRelease(ctx, lockKey, "host died"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ForceRelease(ctx, lockKey, "host died"); !errors.Is(err, dblock.ErrLockNotHeld) {
+		t.Fatalf("expected %v, got %v", dblock.ErrLockNotHeld, err)
+	}
+	if err := lock.Refresh(ctx, time.Hour); !errors.Is(err, dblock.ErrNotObtained) {
+		t.Fatalf("expected %v, got %v", dblock.ErrNotObtained, err)
+	}
+	// at the time of the client clock
+	exp := " at " + clock.Now().Format(time.RFC3339) + ": host died"
+	if record, err := rc.Get(ctx, lockKey+":admin").Result(); err != nil || !strings.HasSuffix(record, exp) {
+		t.Fatalf("expected the record, got %q, %v", record, err)
+	}
+}
+
+func TestClient_Steal(t *testing.T) {
+	ctx := context.Background()
+	rc := redis.NewClient(redisOpts)
+	defer teardown(t, rc)
+
+	client := redislock.New(rc)
+	lock := quickObtain(t, rc, time.Hour)
+	stolen, err := client.Steal(ctx, lockKey, time.Minute, "host died")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stolen.Release(ctx)
+
+	if !strings.HasPrefix(stolen.Metadata(), "stolen by ") {
+		t.Fatalf("expected the record, got %q", stolen.Metadata())
+	}
+	if exp, got := lock.Fence()+1, stolen.Fence(); exp != got {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	if err := lock.Release(ctx); !errors.Is(err, dblock.ErrLockNotHeld) {
+		t.Fatalf("expected %v, got %v", dblock.ErrLockNotHeld, err)
+	}
+	assertTTL(t, stolen, time.Minute)
+}
+
+func TestClient_listener(t *testing.T) {
+	ctx := context.Background()
+	rc := redis.NewClient(redisOpts)
+	defer teardown(t, rc)
+
+	events := make(chan string, 2)
+	client := redislock.New(rc, dblock.WithListener(eventsListener{events: events}))
+	lock, err := client.Obtain(ctx, lockKey, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-lock.Done()
+
+	for _, exp := range []string{"obtain", "expired"} {
+		select {
+		case got := <-events:
+			if exp != got {
+				t.Fatalf("expected %v, got %v", exp, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %v, got nothing", exp)
+		}
+	}
+}
+
+type eventsListener struct {
+	dblock.NopListener
+	events chan<- string
+}
+
+func (l eventsListener) OnObtain(string, dblock.Lock) { l.events <- "obtain" }
+func (l eventsListener) OnExpired(string)             { l.events <- "expired" }
+
 func TestObtain_retry_success(t *testing.T) {
 	ctx := context.Background()
 	rc := redis.NewClient(redisOpts)
@@ -168,6 +769,27 @@ func TestObtain_retry_failure(t *testing.T) {
 	}
 }
 
+func TestObtain_waitTimeout(t *testing.T) {
+	ctx := context.Background()
+	rc := redis.NewClient(redisOpts)
+	defer teardown(t, rc)
+
+	lock, err := redislock.Obtain(ctx, rc, lockKey, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release(ctx)
+
+	start := time.Now()
+	_, err = redislock.Obtain(ctx, rc, lockKey, time.Hour, dblock.WithWaitTimeout(50*time.Millisecond))
+	if exp, got := context.DeadlineExceeded, err; !errors.Is(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected to wait for 50ms, got %v", elapsed)
+	}
+}
+
 func TestObtain_concurrent(t *testing.T) {
 	ctx := context.Background()
 	rc := redis.NewClient(redisOpts)
@@ -226,6 +848,52 @@ func TestLock_Refresh(t *testing.T) {
 	assertTTL(t, lock, time.Minute)
 }
 
+func TestLock_autoRefresh(t *testing.T) {
+	ctx := context.Background()
+	rc := redis.NewClient(redisOpts)
+	defer teardown(t, rc)
+
+	lock, err := redislock.Obtain(ctx, rc, lockKey, 50*time.Millisecond, dblock.WithAutoRefresh(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// outlive the original TTL
+	time.Sleep(120 * time.Millisecond)
+	if ttl, err := lock.TTL(ctx); err != nil {
+		t.Fatal(err)
+	} else if ttl == 0 {
+		t.Fatal("expected the lock to be refreshed")
+	}
+
+	if err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLock_Done(t *testing.T) {
+	ctx := context.Background()
+	rc := redis.NewClient(redisOpts)
+	defer teardown(t, rc)
+
+	lock := quickObtain(t, rc, time.Hour)
+	lockCtx, cancel := lock.Context(ctx)
+	defer cancel()
+
+	select {
+	case <-lock.Done():
+		t.Fatal("expected the lock not to be done")
+	default:
+	}
+
+	if err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	<-lock.Done()
+	<-lockCtx.Done()
+}
+
 func TestLock_Refresh_expired(t *testing.T) {
 	ctx := context.Background()
 	rc := redis.NewClient(redisOpts)
@@ -322,7 +990,9 @@ func assertTTL(t *testing.T, lock dblock.Lock, exp time.Duration) {
 func teardown(t *testing.T, rc *redis.Client) {
 	t.Helper()
 
-	if err := rc.Del(context.Background(), lockKey).Err(); err != nil {
+	if err := rc.Del(context.Background(), lockKey, lockKey+":fence", lockKey+":holds", lockKey+":shared", lockKey+":permits", lockKey+":queue", lockKey+":waiters", lockKey+":admin", lockKey+":holder",
+		lockKey+":queue:fence", lockKey+":queue:holds", lockKey+":queue:holder",
+		otherKey, otherKey+":fence", otherKey+":holds", otherKey+":holder").Err(); err != nil {
 		t.Fatal(err)
 	}
 	if err := rc.Close(); err != nil {
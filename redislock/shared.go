@@ -0,0 +1,220 @@
+package redislock
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+	"github.com/redis/go-redis/v9"
+)
+
+// luaNow sets the local variable now to the redis server time in milliseconds.
+const luaNow = `
+redis.replicate_commands()
+local t = redis.call("time")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+`
+
+// luaExpireMembers keeps the sorted set KEYS[1] alive as long as its last member.
+const luaExpireMembers = `
+local last = redis.call("zrange", KEYS[1], -1, -1, "withscores")
+if last[2] then redis.call("pexpireat", KEYS[1], last[2]) end
+`
+
+var (
+	// luaObtainShared adds the token to the sorted set KEYS[1] scored by its expiring time,
+	// when the exclusive lock KEYS[2] is not held.
+	luaObtainShared = redis.NewScript(luaNow + `
+if redis.call("exists", KEYS[2]) == 1 then return 0 end
+
+redis.call("zremrangebyscore", KEYS[1], "-inf", now)
+redis.call("zadd", KEYS[1], now + tonumber(ARGV[2]), ARGV[1])
+` + luaExpireMembers + `
+return 1
+`)
+	luaMemberRefresh = redis.NewScript(luaNow + `
+local score = redis.call("zscore", KEYS[1], ARGV[1])
+if not score or tonumber(score) <= now then return 0 end
+
+redis.call("zadd", KEYS[1], now + tonumber(ARGV[2]), ARGV[1])
+` + luaExpireMembers + `
+return 1
+`)
+	luaMemberRelease = redis.NewScript(luaNow + `
+local score = redis.call("zscore", KEYS[1], ARGV[1])
+redis.call("zrem", KEYS[1], ARGV[1])
+if not score or tonumber(score) <= now then return 0 end
+return 1
+`)
+	// luaMemberPTTL returns the amount of remaining time in milliseconds.
+	luaMemberPTTL = redis.NewScript(luaNow + `
+local score = redis.call("zscore", KEYS[1], ARGV[1])
+if not score or tonumber(score) <= now then return -3 end
+return tonumber(score) - now
+`)
+	// luaMemberCount returns the number of the alive members.
+	luaMemberCount = redis.NewScript(luaNow + `
+redis.call("zremrangebyscore", KEYS[1], "-inf", now)
+return redis.call("zcard", KEYS[1])
+`)
+)
+
+// sharedSuffix is the suffix of the sorted set key to keep the shared holders of a lock.
+const sharedSuffix = ":shared"
+
+// ObtainShared tries to obtain a shared lock using a key with the given TTL,
+// many shared holders can hold the key at once, while no exclusive lock is held.
+// May return ErrNotObtained if not successful.
+func (c *Client) ObtainShared(ctx context.Context, key string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
+	return c.options.Obtain(ctx, c.obtainShared, key, ttl, optionsFns...)
+}
+
+func (c *Client) obtainShared(ctx context.Context, key string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
+	opt, err := c.options.ParseOptions(optionsFns...)
+	if err != nil {
+		return nil, err
+	}
+
+	ttlVal := strconv.FormatInt(int64(ttl/time.Millisecond), 10)
+	var lock *memberLock
+	err = opt.Retry(ctx, ttl, func(ctx context.Context) (bool, error) {
+		until := opt.Clock.Now().Add(ttl)
+		ok, err := luaObtainShared.Run(ctx, c.client, []string{key + sharedSuffix, key}, opt.Token, ttlVal).Bool()
+		if err != nil || !ok {
+			return false, err
+		}
+
+		lock = &memberLock{
+			Client:   c,
+			Key:      key + sharedSuffix,
+			token:    opt.Token,
+			metadata: opt.Meta,
+			lifetime: dblock.NewLifetime(opt.Clock, until),
+		}
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if opt.AutoRefresh > 0 {
+		lock.refresher = dblock.StartRefresher(opt.Clock, lock, ttl, opt.AutoRefresh, opt.RefreshFailed)
+	}
+	return lock, nil
+}
+
+// ObtainExclusive tries to obtain an exclusive lock using a key with the given TTL,
+// it blocks new shared holders at once, and waits until the present shared holders are gone.
+// May return ErrNotObtained if not successful.
+func (c *Client) ObtainExclusive(ctx context.Context, key string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
+	return c.options.Obtain(ctx, c.obtainExclusive, key, ttl, optionsFns...)
+}
+
+func (c *Client) obtainExclusive(ctx context.Context, key string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
+	opt, err := c.options.ParseOptions(optionsFns...)
+	if err != nil {
+		return nil, err
+	}
+
+	// the exclusive lock blocks new shared holders, wait for the present ones to leave.
+	// the waiter of a fair lock is kept in the queue until obtained or given up.
+	attempt, leave := c.attempts(key, ttl, opt)
+	obtain := func(ctx context.Context, opt *dblock.Options) (dblock.Lock, error) {
+		lock, err := attempt(ctx)
+		if err != nil {
+			return nil, err
+		} else if lock == nil {
+			return nil, dblock.ErrNotObtained
+		}
+		if opt.AutoRefresh > 0 {
+			lock.refresher = dblock.StartRefresher(opt.Clock, lock, ttl, opt.AutoRefresh, opt.RefreshFailed)
+		}
+		return lock, nil
+	}
+	lock, err := opt.ObtainDrained(ctx, ttl, obtain, func(ctx context.Context) (bool, error) {
+		n, err := luaMemberCount.Run(ctx, c.client, []string{key + sharedSuffix}).Int64()
+		return n == 0, err
+	})
+	if err != nil {
+		leave()
+	}
+	return lock, err
+}
+
+// memberLock represents an obtained lock kept as a member of a sorted set.
+type memberLock struct {
+	*Client
+	Key      string
+	token    string
+	metadata string
+
+	lifetime  *dblock.Lifetime
+	refresher *dblock.Refresher
+}
+
+// Token returns the token value set by the lock.
+func (l *memberLock) Token() string { return l.token }
+
+// Metadata returns the metadata of the lock.
+func (l *memberLock) Metadata() string { return l.metadata }
+
+// Fence returns 0, the locks kept in sorted sets are not fenced.
+func (l *memberLock) Fence() uint64 { return 0 }
+
+// Done returns a channel that's closed when the lock is lost.
+func (l *memberLock) Done() <-chan struct{} { return l.lifetime.Done() }
+
+// Context returns a copy of parent which is cancelled when the lock is lost.
+func (l *memberLock) Context(parent context.Context) (context.Context, context.CancelFunc) {
+	return l.lifetime.Context(parent)
+}
+
+// TTL returns the remaining time-to-live. Returns 0 if the lock has expired.
+func (l *memberLock) TTL(ctx context.Context) (time.Duration, error) {
+	num, err := luaMemberPTTL.Run(ctx, l.client, []string{l.Key}, l.token).Int64()
+	if err != nil {
+		return 0, err
+	}
+
+	if num > 0 {
+		return time.Duration(num) * time.Millisecond, nil
+	}
+	l.lifetime.Lose()
+	return 0, nil
+}
+
+// Refresh extends the lock with a new TTL.
+// May return ErrNotObtained if refresh is unsuccessful.
+func (l *memberLock) Refresh(ctx context.Context, ttl time.Duration) error {
+	ttlVal := strconv.FormatInt(int64(ttl/time.Millisecond), 10)
+	until := l.now().Add(ttl)
+	ok, err := luaMemberRefresh.Run(ctx, l.client, []string{l.Key}, l.token, ttlVal).Bool()
+	if err != nil {
+		return err
+	}
+	if ok {
+		l.lifetime.Extend(until)
+		return nil
+	}
+	l.lifetime.Lose()
+	return dblock.ErrNotObtained
+}
+
+// Release manually releases the lock.
+// May return ErrLockNotHeld.
+func (l *memberLock) Release(ctx context.Context) error {
+	if l.refresher != nil {
+		l.refresher.Stop()
+	}
+	defer l.lifetime.Lose()
+
+	ok, err := luaMemberRelease.Run(ctx, l.client, []string{l.Key}, l.token).Bool()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return dblock.ErrLockNotHeld
+	}
+	return nil
+}
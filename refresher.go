@@ -0,0 +1,82 @@
+package dblock
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Refreshable is the lock which can be refreshed, like Lock and MultiLock.
+type Refreshable interface {
+	// Refresh extends the lock with a new TTL.
+	Refresh(ctx context.Context, ttl time.Duration) error
+}
+
+// Refresher refreshes an obtained lock in background.
+type Refresher struct {
+	mu   sync.Mutex
+	stop chan struct{}
+	once sync.Once
+}
+
+// StartRefresher starts to refresh the lock with the ttl every interval of the clock,
+// until Stop is called or the lock is lost.
+// failed, if not nil, is called with every refreshing error.
+func StartRefresher(clock Clock, lock Refreshable, ttl, interval time.Duration, failed func(err error)) *Refresher {
+	if interval <= 0 || interval >= ttl {
+		interval = ttl / 2
+	}
+
+	r := &Refresher{stop: make(chan struct{})}
+	go r.run(clock.NewTimer(interval), lock, ttl, interval, failed)
+	return r
+}
+
+func (r *Refresher) run(timer Timer, lock Refreshable, ttl, interval time.Duration, failed func(err error)) {
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-timer.C():
+		}
+		timer.Reset(interval)
+
+		err := r.refresh(lock, ttl, interval)
+		if err == nil {
+			continue
+		}
+		if failed != nil {
+			failed(err)
+		}
+		if errors.Is(err, ErrNotObtained) {
+			return
+		}
+	}
+}
+
+func (r *Refresher) refresh(lock Refreshable, ttl, timeout time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	select {
+	case <-r.stop:
+		return nil
+	default:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return lock.Refresh(ctx, ttl)
+}
+
+// Stop stops the refreshing, and waits for the in-flight refreshing to finish.
+func (r *Refresher) Stop() {
+	r.once.Do(func() { close(r.stop) })
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+}
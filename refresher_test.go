@@ -0,0 +1,65 @@
+package dblock_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+	"github.com/bingoohuang/dblock/clocktest"
+)
+
+type refreshLock struct {
+	dblock.Lock
+	refreshed int32
+	err       error
+	// called receives after each refresh.
+	called chan struct{}
+}
+
+func newRefreshLock(err error) *refreshLock {
+	return &refreshLock{err: err, called: make(chan struct{}, 10)}
+}
+
+func (l *refreshLock) Refresh(context.Context, time.Duration) error {
+	atomic.AddInt32(&l.refreshed, 1)
+	l.called <- struct{}{}
+	return l.err
+}
+
+func TestRefresher(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Now())
+	lock := newRefreshLock(nil)
+	r := dblock.StartRefresher(clock, lock, time.Second, 10*time.Millisecond, nil)
+	for i := 0; i < 3; i++ {
+		clock.WaitTimers(1)
+		clock.Advance(10 * time.Millisecond)
+		<-lock.called
+	}
+	r.Stop()
+
+	clock.Advance(time.Minute)
+	if got := atomic.LoadInt32(&lock.refreshed); got != 3 {
+		t.Fatalf("expected 3 refreshes, got %d", got)
+	}
+}
+
+func TestRefresher_lost(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Now())
+	lock := newRefreshLock(dblock.ErrNotObtained)
+	failed := make(chan error, 10)
+	r := dblock.StartRefresher(clock, lock, time.Second, 10*time.Millisecond, func(err error) { failed <- err })
+	defer r.Stop()
+
+	clock.Advance(10 * time.Millisecond)
+	if err := <-failed; !errors.Is(err, dblock.ErrNotObtained) {
+		t.Fatalf("expected %v, got %v", dblock.ErrNotObtained, err)
+	}
+
+	clock.Advance(time.Minute)
+	if got := atomic.LoadInt32(&lock.refreshed); got != 1 {
+		t.Fatalf("expected refreshing to stop after the lock is lost, got %d refreshes", got)
+	}
+}
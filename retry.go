@@ -1,77 +1,437 @@
 package dblock
 
 import (
-	"sync/atomic"
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
 	"time"
 )
 
 // RetryStrategy allows to customise the lock retry strategy.
+// It is a factory of the Retrier for each Obtain, so that one strategy can be defined once and shared
+// by the concurrent Obtain calls.
 type RetryStrategy interface {
-	// NextBackoff returns the next backoff duration.
-	NextBackoff() time.Duration
+	// NewRetrier returns a fresh Retrier for an Obtain.
+	NewRetrier() Retrier
 }
 
-type linearBackoff time.Duration
+// Retrier decides the backoffs of the retries of an Obtain.
+type Retrier interface {
+	// NextBackoff returns the backoff before the next attempt, or 0 to stop retrying.
+	NextBackoff(state RetryState) time.Duration
+}
+
+// RetryState is the state of the retrying passed to the Retrier.
+type RetryState struct {
+	// Attempt is the number of the failed attempts, starting from 1.
+	Attempt int
+	// Elapsed is the time passed since the first attempt.
+	Elapsed time.Duration
+	// Err is the error of the last attempt, ErrNotObtained if the lock is held by others.
+	Err error
+}
+
+// Contended reports whether the last attempt failed because the lock is held by others.
+func (s RetryState) Contended() bool { return errors.Is(s.Err, ErrNotObtained) }
+
+// RetryStrategyFunc is a RetryStrategy function returning a fresh Retrier.
+type RetryStrategyFunc func() Retrier
+
+// NewRetrier calls f.
+func (f RetryStrategyFunc) NewRetrier() Retrier { return f() }
+
+// RetrierFunc is a stateless Retrier function, which is also a RetryStrategy returning itself.
+// It is called for the errors too, check RetryState.Contended to retry only when the lock is held by others.
+type RetrierFunc func(state RetryState) time.Duration
+
+// NextBackoff calls f.
+func (f RetrierFunc) NextBackoff(state RetryState) time.Duration { return f(state) }
+
+// NewRetrier returns f itself, since it is stateless.
+func (f RetrierFunc) NewRetrier() Retrier { return f }
+
+// contended returns a stateless strategy which retries with the backoff only when the lock is held by others.
+func contended(backoff func(state RetryState) time.Duration) RetrierFunc {
+	return func(state RetryState) time.Duration {
+		if !state.Contended() {
+			return 0
+		}
+		return backoff(state)
+	}
+}
 
 // LinearBackoff allows retries regularly with customized intervals
 func LinearBackoff(backoff time.Duration) RetryStrategy {
-	return linearBackoff(backoff)
+	return contended(func(RetryState) time.Duration { return backoff })
 }
 
 // NoRetry acquire the lock only once.
 func NoRetry() RetryStrategy {
-	return linearBackoff(0)
+	return LinearBackoff(0)
 }
 
-func (r linearBackoff) NextBackoff() time.Duration {
-	return time.Duration(r)
+// LimitRetry limits the number of retries to max attempts.
+func LimitRetry(s RetryStrategy, max int) RetryStrategy {
+	return RetryStrategyFunc(func() Retrier {
+		r := s.NewRetrier()
+		return RetrierFunc(func(state RetryState) time.Duration {
+			if state.Attempt > max {
+				return 0
+			}
+			return r.NextBackoff(state)
+		})
+	})
 }
 
-type limitedRetry struct {
-	s   RetryStrategy
-	cnt int64
-	max int64
+// RetryOnError retries the errors for which retryable returns true, like the contended lock,
+// e.g. the transient network errors. The strategies do not retry the errors by default.
+func RetryOnError(s RetryStrategy, retryable func(err error) bool) RetryStrategy {
+	return RetryStrategyFunc(func() Retrier {
+		r := s.NewRetrier()
+		return RetrierFunc(func(state RetryState) time.Duration {
+			if !state.Contended() && retryable(state.Err) {
+				state.Err = ErrNotObtained
+			}
+			return r.NextBackoff(state)
+		})
+	})
 }
 
-// LimitRetry limits the number of retries to max attempts.
-func LimitRetry(s RetryStrategy, max int) RetryStrategy {
-	return &limitedRetry{s: s, max: int64(max)}
+// ExponentialBackoff strategy is an optimization strategy with a retry time of 2**n milliseconds (n means number of times).
+// You can set a minimum and maximum value, the recommended minimum value is not less than 16ms.
+func ExponentialBackoff(min, max time.Duration) RetryStrategy {
+	return contended(func(state RetryState) time.Duration {
+		ms := 2 << 25
+		if state.Attempt < 25 {
+			ms = 2 << state.Attempt
+		}
+
+		switch d := time.Duration(ms) * time.Millisecond; {
+		case d < min:
+			return min
+		case max != 0 && d > max:
+			return max
+		default:
+			return d
+		}
+	})
 }
 
-func (r *limitedRetry) NextBackoff() time.Duration {
-	if atomic.LoadInt64(&r.cnt) >= r.max {
-		return 0
+// Jitter creates the jittered strategies with a random source, which spread the retries of many contenders
+// to avoid the thundering herd. The strategies created by the same Jitter share the source safely.
+type Jitter struct {
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// NewJitter creates a Jitter with the random source, like rand.NewSource(seed) for deterministic tests.
+func NewJitter(src rand.Source) *Jitter {
+	return &Jitter{rnd: rand.New(src)}
+}
+
+var defaultJitter = NewJitter(rand.NewSource(time.Now().UnixNano()))
+
+// between returns a random duration in [min, max].
+func (j *Jitter) between(min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
 	}
-	atomic.AddInt64(&r.cnt, 1)
-	return r.s.NextBackoff()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return min + time.Duration(j.rnd.Int63n(int64(max-min)+1))
 }
 
-type exponentialBackoff struct {
-	cnt uint64
+// JitteredBackoff retries at the interval of backoff plus or minus a random jitter,
+// the interval is half of the backoff at least, even if jitter is greater.
+func JitteredBackoff(backoff, jitter time.Duration) RetryStrategy {
+	return defaultJitter.JitteredBackoff(backoff, jitter)
+}
 
-	min, max time.Duration
+// JitteredBackoff retries at the interval of backoff plus or minus a random jitter,
+// the interval is half of the backoff at least, even if jitter is greater.
+func (j *Jitter) JitteredBackoff(backoff, jitter time.Duration) RetryStrategy {
+	// not to busy-loop against the backend when jitter is close to backoff
+	min := backoff - jitter
+	if min < backoff/2 {
+		min = backoff / 2
+	}
+	return contended(func(RetryState) time.Duration {
+		if d := j.between(min, backoff+jitter); d > 0 {
+			return d
+		}
+		return 1
+	})
 }
 
-// ExponentialBackoff strategy is an optimization strategy with a retry time of 2**n milliseconds (n means number of times).
-// You can set a minimum and maximum value, the recommended minimum value is not less than 16ms.
-func ExponentialBackoff(min, max time.Duration) RetryStrategy {
-	return &exponentialBackoff{min: min, max: max}
+// FullJitter retries after a random duration between 0 and the exponential backoff base*2**n, capped by max.
+func FullJitter(base, max time.Duration) RetryStrategy {
+	return defaultJitter.FullJitter(base, max)
+}
+
+// FullJitter retries after a random duration between 0 and the exponential backoff base*2**n, capped by max.
+func (j *Jitter) FullJitter(base, max time.Duration) RetryStrategy {
+	if max <= 0 {
+		max = math.MaxInt64
+	}
+	return contended(func(state RetryState) time.Duration {
+		d, n := max, state.Attempt-1
+		if n < 63 && base <= math.MaxInt64>>n && base<<n < d {
+			d = base << n
+		}
+		// never returns 0, which stops retrying
+		return j.between(1, d)
+	})
+}
+
+// DecorrelatedJitter retries after a random duration between base and 3 times the previous backoff, capped by max.
+func DecorrelatedJitter(base, max time.Duration) RetryStrategy {
+	return defaultJitter.DecorrelatedJitter(base, max)
+}
+
+// DecorrelatedJitter retries after a random duration between base and 3 times the previous backoff, capped by max.
+func (j *Jitter) DecorrelatedJitter(base, max time.Duration) RetryStrategy {
+	return RetryStrategyFunc(func() Retrier {
+		prev := base
+		return contended(func(RetryState) time.Duration {
+			d := j.between(base, prev*3)
+			if max > 0 && d > max {
+				d = max
+			}
+			if d < 1 {
+				d = 1
+			}
+			prev = d
+			return d
+		})
+	})
+}
+
+// MaxElapsed stops retrying when max passed since the first attempt,
+// and shortens the last backoff to not exceed it.
+func MaxElapsed(s RetryStrategy, max time.Duration) RetryStrategy {
+	return RetryStrategyFunc(func() Retrier {
+		r := s.NewRetrier()
+		return RetrierFunc(func(state RetryState) time.Duration {
+			rest := max - state.Elapsed
+			if rest < 1 {
+				return 0
+			}
+			if d := r.NextBackoff(state); d < rest {
+				return d
+			}
+			return rest
+		})
+	})
+}
+
+// CapBackoff limits the backoff of the strategy to max at most, max <= 0 means no limit.
+func CapBackoff(s RetryStrategy, max time.Duration) RetryStrategy {
+	if max <= 0 {
+		return s
+	}
+	return RetryStrategyFunc(func() Retrier {
+		r := s.NewRetrier()
+		return RetrierFunc(func(state RetryState) time.Duration {
+			if d := r.NextBackoff(state); d < max {
+				return d
+			}
+			return max
+		})
+	})
+}
+
+// Retry calls obtain until it succeeds, or the retry strategy gives up, waiting for the backoffs by the clock.
+// When ctx has no deadline, the retrying is limited within the wait, usually the ttl of the lock,
+// or until ctx is done if wait is 0, see Options.GetWaitTimeout.
+// May return ErrNotObtained if not successful, or the error of the last attempt.
+func Retry(ctx context.Context, clock Clock, wait time.Duration, strategy RetryStrategy, obtain func(ctx context.Context) (bool, error)) error {
+	return RetryWake(ctx, clock, wait, strategy, nil, obtain)
 }
 
-func (r *exponentialBackoff) NextBackoff() time.Duration {
-	cnt := atomic.AddUint64(&r.cnt, 1)
+// RetryWake is like Retry, but also retries at once when the channel returned by wake receives,
+// e.g. the lock is released, while the retry strategy is kept as the fallback.
+// wake is called once before the first waiting with a ctx which is cancelled when RetryWake returns,
+// and may return nil when the notification is not available.
+func RetryWake(ctx context.Context, clock Clock, wait time.Duration, strategy RetryStrategy,
+	wake func(ctx context.Context) <-chan struct{}, obtain func(ctx context.Context) (bool, error),
+) error {
+	// make sure we don't retry forever, unless asked to.
+	if _, ok := ctx.Deadline(); !ok && wait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = withTimeout(ctx, clock, wait)
+		defer cancel()
+	}
 
-	ms := 2 << 25
-	if cnt < 25 {
-		ms = 2 << cnt
+	retrier := strategy.NewRetrier()
+	start := clock.Now()
+	trace := ContextRetryTrace(ctx)
+	attempt := 0
+	tryObtain := func() (bool, error) {
+		attempt++
+		if trace == nil {
+			return obtain(ctx)
+		}
+		if trace.Attempt != nil {
+			trace.Attempt(attempt)
+		}
+		ok, err := obtain(ctx)
+		if trace.AttemptDone != nil {
+			trace.AttemptDone(attempt, ok, err)
+		}
+		return ok, err
 	}
 
-	switch d := time.Duration(ms) * time.Millisecond; {
-	case d < r.min:
-		return r.min
-	case r.max != 0 && d > r.max:
-		return r.max
-	default:
-		return d
+	var timer Timer
+	var wakeC <-chan struct{}
+	for state := (RetryState{}); ; {
+		ok, err := tryObtain()
+		if ok && err == nil {
+			return nil
+		} else if err == nil {
+			err = ErrNotObtained
+		}
+
+		state.Attempt++
+		state.Elapsed, state.Err = clock.Now().Sub(start), err
+		backoff := retrier.NextBackoff(state)
+		if backoff < 1 {
+			if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+				// may be cancelled by the deadline
+				return ctxErr(ctx)
+			}
+			return err
+		}
+
+		if wake != nil {
+			wakeCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			wakeC, wake = wake(wakeCtx), nil
+
+			// try again at once, in case of the wakeup missed before waiting,
+			// the error is left to the next attempt after the backoff.
+			if ok, err := tryObtain(); ok && err == nil {
+				return nil
+			}
+		}
+
+		if timer == nil {
+			timer = clock.NewTimer(backoff)
+			defer timer.Stop()
+		} else {
+			timer.Reset(backoff)
+		}
+
+		if trace != nil && trace.Wait != nil {
+			trace.Wait(backoff)
+		}
+		woken := false
+		select {
+		case <-ctx.Done():
+		case <-timer.C():
+		case <-wakeC:
+			woken = true
+		}
+		if trace != nil && trace.WaitDone != nil {
+			trace.WaitDone(woken)
+		}
+		if err := ctxErr(ctx); err != nil {
+			return err
+		}
 	}
 }
+
+// withTimeout returns a copy of ctx which is cancelled after d of the clock.
+// With the system clock, ctx reports the deadline, e.g. to the network timeouts of the drivers,
+// otherwise, e.g. with a fake clock, context.DeadlineExceeded is the cause of ctx, see ctxErr.
+func withTimeout(ctx context.Context, clock Clock, d time.Duration) (context.Context, context.CancelFunc) {
+	if realClock(clock) {
+		return context.WithTimeout(ctx, d)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	deadline := clock.AfterFunc(d, func() { cancel(context.DeadlineExceeded) })
+	return ctx, func() {
+		deadline.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// ctxErr returns the error of ctx, context.DeadlineExceeded if it is cancelled by the deadline of the clock.
+func ctxErr(ctx context.Context) error {
+	err := ctx.Err()
+	if err != nil && errors.Is(context.Cause(ctx), context.DeadlineExceeded) {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+// RetryTrace is a set of hooks to run at the stages of the retrying, like httptrace.ClientTrace,
+// any of the hooks may be nil.
+type RetryTrace struct {
+	// Attempt is called before each attempt of obtaining, attempt starts from 1.
+	Attempt func(attempt int)
+	// AttemptDone is called with the result of the attempt.
+	AttemptDone func(attempt int, ok bool, err error)
+	// Wait is called before waiting for the backoff.
+	Wait func(backoff time.Duration)
+	// WaitDone is called when the waiting ends, woken is true if woken up by the release notification.
+	WaitDone func(woken bool)
+}
+
+type retryTraceKey struct{}
+
+// WithRetryTrace returns a new context based on the parent ctx, the retrying in Obtain with the ctx runs the hooks of trace.
+// The hooks of the trace already in ctx, if any, run after the hooks of trace.
+func WithRetryTrace(ctx context.Context, trace *RetryTrace) context.Context {
+	if old := ContextRetryTrace(ctx); old != nil {
+		trace = trace.compose(old)
+	}
+	return context.WithValue(ctx, retryTraceKey{}, trace)
+}
+
+// compose returns a new RetryTrace running the hooks of t then old.
+func (t *RetryTrace) compose(old *RetryTrace) *RetryTrace {
+	return &RetryTrace{
+		Attempt: func(attempt int) {
+			if t.Attempt != nil {
+				t.Attempt(attempt)
+			}
+			if old.Attempt != nil {
+				old.Attempt(attempt)
+			}
+		},
+		AttemptDone: func(attempt int, ok bool, err error) {
+			if t.AttemptDone != nil {
+				t.AttemptDone(attempt, ok, err)
+			}
+			if old.AttemptDone != nil {
+				old.AttemptDone(attempt, ok, err)
+			}
+		},
+		Wait: func(backoff time.Duration) {
+			if t.Wait != nil {
+				t.Wait(backoff)
+			}
+			if old.Wait != nil {
+				old.Wait(backoff)
+			}
+		},
+		WaitDone: func(woken bool) {
+			if t.WaitDone != nil {
+				t.WaitDone(woken)
+			}
+			if old.WaitDone != nil {
+				old.WaitDone(woken)
+			}
+		},
+	}
+}
+
+// ContextRetryTrace returns the RetryTrace associated with the ctx, or nil if none.
+func ContextRetryTrace(ctx context.Context) *RetryTrace {
+	trace, _ := ctx.Value(retryTraceKey{}).(*RetryTrace)
+	return trace
+}
@@ -1,14 +1,55 @@
 package dblock_test
 
 import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/bingoohuang/dblock"
+	"github.com/bingoohuang/dblock/clocktest"
+	"github.com/bingoohuang/dblock/dblocktest"
 )
 
+// contender iterates the backoffs of a fresh retrier for the contended attempts, the elapsed time is told by a fake clock.
+type contender struct {
+	r       dblock.Retrier
+	clock   *clocktest.FakeClock
+	start   time.Time
+	attempt int
+}
+
+func newContender(s dblock.RetryStrategy) *contender {
+	clock := clocktest.NewFakeClock(time.Now())
+	return &contender{r: s.NewRetrier(), clock: clock, start: clock.Now()}
+}
+
+func (c *contender) NextBackoff() time.Duration {
+	c.attempt++
+	return c.r.NextBackoff(dblock.RetryState{Attempt: c.attempt, Elapsed: c.clock.Now().Sub(c.start), Err: dblock.ErrNotObtained})
+}
+
+// obtainAdvancing calls obtain in a goroutine, and advances the clock by each backoff of the retrying until it returns.
+func obtainAdvancing(ctx context.Context, clock *clocktest.FakeClock, obtain func(ctx context.Context) error) error {
+	waits := make(chan time.Duration)
+	ctx = dblock.WithRetryTrace(ctx, &dblock.RetryTrace{Wait: func(backoff time.Duration) { waits <- backoff }})
+
+	done := make(chan error, 1)
+	go func() { done <- obtain(ctx) }()
+	for {
+		select {
+		case err := <-done:
+			return err
+		case backoff := <-waits:
+			clock.Advance(backoff)
+		}
+	}
+}
+
 func TestNoRetry(t *testing.T) {
-	retry := dblock.NoRetry()
+	retry := newContender(dblock.NoRetry())
 	for i, exp := range []time.Duration{0, 0, 0} {
 		if got := retry.NextBackoff(); exp != got {
 			t.Fatalf("expected %d to be %v, got %v", i, exp, got)
@@ -17,7 +58,7 @@ func TestNoRetry(t *testing.T) {
 }
 
 func TestLinearBackoff(t *testing.T) {
-	retry := dblock.LinearBackoff(time.Second)
+	retry := newContender(dblock.LinearBackoff(time.Second))
 	for i, exp := range []time.Duration{
 		time.Second,
 		time.Second,
@@ -30,7 +71,7 @@ func TestLinearBackoff(t *testing.T) {
 }
 
 func TestExponentialBackoff(t *testing.T) {
-	retry := dblock.ExponentialBackoff(10*time.Millisecond, 300*time.Millisecond)
+	retry := newContender(dblock.ExponentialBackoff(10*time.Millisecond, 300*time.Millisecond))
 	for i, exp := range []time.Duration{
 		10 * time.Millisecond,
 		10 * time.Millisecond,
@@ -49,7 +90,7 @@ func TestExponentialBackoff(t *testing.T) {
 }
 
 func TestLimitRetry(t *testing.T) {
-	retry := dblock.LimitRetry(dblock.LinearBackoff(time.Second), 2)
+	retry := newContender(dblock.LimitRetry(dblock.LinearBackoff(time.Second), 2))
 	for i, exp := range []time.Duration{
 		time.Second,
 		time.Second,
@@ -60,3 +101,406 @@ func TestLimitRetry(t *testing.T) {
 		}
 	}
 }
+
+func TestRetryWake(t *testing.T) {
+	wake := make(chan struct{}, 1)
+	var attempts int32
+	start := time.Now()
+	err := dblock.RetryWake(context.Background(), dblock.SystemClock, time.Minute, dblock.LinearBackoff(time.Hour),
+		func(context.Context) <-chan struct{} { return wake },
+		func(context.Context) (bool, error) {
+			// released after the subscription
+			if atomic.AddInt32(&attempts, 1) == 2 {
+				wake <- struct{}{}
+			}
+			return atomic.LoadInt32(&attempts) > 2, nil
+		})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, got := int32(3), atomic.LoadInt32(&attempts); exp != got {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected woken at once, got %v", elapsed)
+	}
+}
+
+func TestJitteredBackoff(t *testing.T) {
+	retry := newContender(dblock.NewJitter(rand.NewSource(1)).JitteredBackoff(100*time.Millisecond, 20*time.Millisecond))
+	seen := map[time.Duration]bool{}
+	for i := 0; i < 100; i++ {
+		got := retry.NextBackoff()
+		if got < 80*time.Millisecond || got > 120*time.Millisecond {
+			t.Fatalf("expected %d in [80ms, 120ms], got %v", i, got)
+		}
+		seen[got] = true
+	}
+	if len(seen) < 50 {
+		t.Fatalf("expected jittered backoffs, got %d distinct", len(seen))
+	}
+}
+
+func TestJitteredBackoff_largeJitter(t *testing.T) {
+	// the lower bound is clamped to the half of the backoff
+	retry := newContender(dblock.NewJitter(rand.NewSource(1)).JitteredBackoff(100*time.Millisecond, 200*time.Millisecond))
+	for i := 0; i < 100; i++ {
+		if got := retry.NextBackoff(); got < 50*time.Millisecond || got > 300*time.Millisecond {
+			t.Fatalf("expected %d in [50ms, 300ms], got %v", i, got)
+		}
+	}
+}
+
+func TestFullJitter(t *testing.T) {
+	retry := newContender(dblock.NewJitter(rand.NewSource(1)).FullJitter(10*time.Millisecond, 100*time.Millisecond))
+	for i, max := range []time.Duration{
+		10 * time.Millisecond,
+		20 * time.Millisecond,
+		40 * time.Millisecond,
+		80 * time.Millisecond,
+		100 * time.Millisecond,
+		100 * time.Millisecond,
+	} {
+		if got := retry.NextBackoff(); got < 1 || got > max {
+			t.Fatalf("expected %d in (0, %v], got %v", i, max, got)
+		}
+	}
+}
+
+func TestDecorrelatedJitter(t *testing.T) {
+	retry := newContender(dblock.NewJitter(rand.NewSource(1)).DecorrelatedJitter(10*time.Millisecond, time.Second))
+	prev := 10 * time.Millisecond
+	for i := 0; i < 20; i++ {
+		got := retry.NextBackoff()
+		if got < 10*time.Millisecond || got > prev*3 || got > time.Second {
+			t.Fatalf("expected %d in [10ms, min(%v, 1s)], got %v", i, prev*3, got)
+		}
+		prev = got
+	}
+}
+
+func TestJitter_seed(t *testing.T) {
+	r1 := newContender(dblock.NewJitter(rand.NewSource(42)).FullJitter(time.Millisecond, time.Second))
+	r2 := newContender(dblock.NewJitter(rand.NewSource(42)).FullJitter(time.Millisecond, time.Second))
+	for i := 0; i < 10; i++ {
+		if b1, b2 := r1.NextBackoff(), r2.NextBackoff(); b1 != b2 {
+			t.Fatalf("expected %d to be the same, got %v and %v", i, b1, b2)
+		}
+	}
+}
+
+func TestCapBackoff(t *testing.T) {
+	retry := newContender(dblock.CapBackoff(dblock.ExponentialBackoff(10*time.Millisecond, time.Second), 20*time.Millisecond))
+	for i, exp := range []time.Duration{
+		10 * time.Millisecond,
+		10 * time.Millisecond,
+		16 * time.Millisecond,
+		20 * time.Millisecond,
+		20 * time.Millisecond,
+	} {
+		if got := retry.NextBackoff(); exp != got {
+			t.Fatalf("expected %d to be %v, got %v", i, exp, got)
+		}
+	}
+	if got := newContender(dblock.CapBackoff(dblock.NoRetry(), time.Second)).NextBackoff(); got != 0 {
+		t.Fatalf("expected no retry, got %v", got)
+	}
+	// no cap
+	if got := newContender(dblock.CapBackoff(dblock.LinearBackoff(time.Second), 0)).NextBackoff(); got != time.Second {
+		t.Fatalf("expected %v, got %v", time.Second, got)
+	}
+}
+
+func TestMaxElapsed(t *testing.T) {
+	retry := newContender(dblock.MaxElapsed(dblock.LinearBackoff(20*time.Millisecond), 50*time.Millisecond))
+	if got := retry.NextBackoff(); got != 20*time.Millisecond {
+		t.Fatalf("expected 20ms, got %v", got)
+	}
+	retry.clock.Advance(40 * time.Millisecond)
+	if got := retry.NextBackoff(); got != 10*time.Millisecond {
+		t.Fatalf("expected the rest 10ms, got %v", got)
+	}
+	retry.clock.Advance(10 * time.Millisecond)
+	if got := retry.NextBackoff(); got != 0 {
+		t.Fatalf("expected no retry, got %v", got)
+	}
+}
+
+func TestLimitRetry_shared(t *testing.T) {
+	strategy := dblock.LimitRetry(dblock.LinearBackoff(time.Millisecond), 2)
+	for i := 0; i < 3; i++ {
+		var attempts int
+		err := dblock.Retry(context.Background(), dblock.SystemClock, time.Minute, strategy, func(context.Context) (bool, error) {
+			attempts++
+			return false, nil
+		})
+		if !errors.Is(err, dblock.ErrNotObtained) {
+			t.Fatalf("expected %v, got %v", dblock.ErrNotObtained, err)
+		}
+		if attempts != 3 {
+			t.Fatalf("expected 3 attempts of Retry %d, got %d", i, attempts)
+		}
+	}
+}
+
+func TestRetryOnError(t *testing.T) {
+	errTransient := errors.New("transient")
+	var states []dblock.RetryState
+	strategy := dblock.RetryOnError(dblock.RetrierFunc(func(state dblock.RetryState) time.Duration {
+		states = append(states, state)
+		if !state.Contended() {
+			return 0
+		}
+		return time.Millisecond
+	}), func(err error) bool { return errors.Is(err, errTransient) })
+
+	var attempts int
+	err := dblock.Retry(context.Background(), dblock.SystemClock, time.Minute, strategy, func(context.Context) (bool, error) {
+		attempts++
+		switch attempts {
+		case 1:
+			return false, errTransient
+		case 2:
+			return false, nil
+		default:
+			return true, nil
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 2 || states[0].Attempt != 1 || states[1].Attempt != 2 || !states[0].Contended() {
+		t.Fatalf("unexpected states %v", states)
+	}
+
+	// the errors are not retried by default
+	err = dblock.Retry(context.Background(), dblock.SystemClock, time.Minute, dblock.LinearBackoff(time.Millisecond), func(context.Context) (bool, error) {
+		return false, errTransient
+	})
+	if !errors.Is(err, errTransient) {
+		t.Fatalf("expected %v, got %v", errTransient, err)
+	}
+}
+
+func TestWithWaitTimeout(t *testing.T) {
+	ctx := context.Background()
+	clock := clocktest.NewFakeClock(time.Now())
+	client := dblocktest.NewClient(dblock.WithClock(clock))
+	if _, err := client.Obtain(ctx, "key", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// waits for 50ms, not the hour of the ttl
+	start := clock.Now()
+	err := obtainAdvancing(ctx, clock, func(ctx context.Context) error {
+		_, err := client.Obtain(ctx, "key", time.Hour, dblock.WithWaitTimeout(50*time.Millisecond))
+		return err
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if elapsed := clock.Now().Sub(start); elapsed != 50*time.Millisecond {
+		t.Fatalf("expected to wait for 50ms, got %v", elapsed)
+	}
+}
+
+func TestOptions_Retry_waitTimeout(t *testing.T) {
+	opt, err := dblock.ParseOptions(dblock.WithWaitTimeout(50 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := clocktest.NewFakeClock(time.Now())
+	opt.Clock = clock
+
+	// the attempt hanging in the backend is cut off at the wait timeout, not the hour of the ttl
+	go func() {
+		clock.WaitTimers(1)
+		clock.Advance(50 * time.Millisecond)
+	}()
+	err = opt.Retry(context.Background(), time.Hour, func(ctx context.Context) (bool, error) {
+		<-ctx.Done()
+		return false, ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestOptions_ObtainDrained(t *testing.T) {
+	ctx := context.Background()
+	clock := clocktest.NewFakeClock(time.Now())
+	client := dblocktest.NewClient(dblock.WithClock(clock))
+	opt, err := dblock.ParseOptions(dblock.WithRetryStrategy(dblock.LinearBackoff(time.Millisecond)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt.Clock = clock
+
+	var obtains int
+	refreshed := make(chan struct{}, 10)
+	obtain := func(ctx context.Context, opt *dblock.Options) (dblock.Lock, error) {
+		obtains++
+		lock, err := client.Obtain(ctx, "key", 100*time.Millisecond, func(o *dblock.Options) { *o = *opt })
+		if err != nil {
+			return nil, err
+		}
+		return &refreshedLock{Lock: lock, refreshed: refreshed}, nil
+	}
+	// the lock is kept while waiting longer than its ttl
+	lock, err := opt.ObtainDrained(ctx, 100*time.Millisecond, obtain, func(context.Context) (bool, error) {
+		for i := 0; i < 3; i++ {
+			clock.Advance(60 * time.Millisecond)
+			select {
+			case <-refreshed:
+			case <-time.After(time.Second):
+				return false, errors.New("not refreshed while waiting")
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.Release(ctx)
+
+	if !client.Held("key") || obtains != 1 {
+		t.Fatalf("expected the lock obtained once and held, got %d obtains", obtains)
+	}
+	if ttl, err := lock.TTL(ctx); err != nil || ttl != 100*time.Millisecond {
+		t.Fatalf("expected the lease restarted, got %v, %v", ttl, err)
+	}
+}
+
+func TestOptions_ObtainDrained_waitTimeout(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Now())
+	client := dblocktest.NewClient(dblock.WithClock(clock))
+	opt, err := dblock.ParseOptions(dblock.WithWaitTimeout(50 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opt.Clock = clock
+
+	obtain := func(ctx context.Context, opt *dblock.Options) (dblock.Lock, error) {
+		return client.Obtain(ctx, "key", time.Hour, func(o *dblock.Options) { *o = *opt })
+	}
+	// the wait timeout and the deadline of the waiting, and the refreshing
+	go func() {
+		clock.WaitTimers(2)
+		clock.Advance(50 * time.Millisecond)
+	}()
+	_, err = opt.ObtainDrained(context.Background(), time.Hour, obtain, func(ctx context.Context) (bool, error) {
+		<-ctx.Done()
+		return false, ctx.Err()
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if client.Held("key") {
+		t.Fatal("expected the lock released")
+	}
+}
+
+func TestOptions_WaiterTTL(t *testing.T) {
+	opt, err := dblock.ParseOptions(dblock.WithRetryStrategy(dblock.ExponentialBackoff(10*time.Millisecond, time.Second)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waiterTTL := opt.WaiterTTL(time.Minute)
+	if got := waiterTTL(); got != time.Minute {
+		t.Fatalf("expected the ttl before the first backoff, got %v", got)
+	}
+
+	c := newContender(opt.GetRetryStrategy())
+	for i := 0; i < 3; i++ {
+		if backoff, got := c.NextBackoff(), waiterTTL(); got != 3*backoff {
+			t.Fatalf("expected 3 times the backoff %v, got %v", backoff, got)
+		}
+	}
+
+	// at most the ttl
+	waiterTTL = opt.WaiterTTL(20 * time.Millisecond)
+	newContender(opt.GetRetryStrategy()).NextBackoff()
+	if got := waiterTTL(); got != 20*time.Millisecond {
+		t.Fatalf("expected the ttl, got %v", got)
+	}
+}
+
+// refreshedLock tells when the lock is refreshed.
+type refreshedLock struct {
+	dblock.Lock
+	refreshed chan struct{}
+}
+
+func (l *refreshedLock) Refresh(ctx context.Context, ttl time.Duration) error {
+	err := l.Lock.Refresh(ctx, ttl)
+	l.refreshed <- struct{}{}
+	return err
+}
+
+func TestWithBlocking(t *testing.T) {
+	ctx := context.Background()
+	clock := clocktest.NewFakeClock(time.Now())
+	client := dblocktest.NewClient(dblock.WithClock(clock))
+	lock, err := client.Obtain(ctx, "key", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.AfterFunc(100*time.Millisecond, func() { _ = lock.Release(ctx) })
+
+	// waits longer than the ttl until released
+	if _, err := client.Obtain(ctx, "key2", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	err = obtainAdvancing(ctx, clock, func(ctx context.Context) error {
+		_, err := client.Obtain(ctx, "key", 10*time.Millisecond, dblock.WithBlocking())
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// until ctx is done
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := client.Obtain(timeoutCtx, "key2", time.Minute, dblock.WithBlocking()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestRetry_clock(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Now())
+	start := clock.Now()
+
+	// the wait is a deadline of the clock
+	var attempts int
+	err := obtainAdvancing(context.Background(), clock, func(ctx context.Context) error {
+		return dblock.Retry(ctx, clock, time.Minute, dblock.LinearBackoff(time.Second), func(context.Context) (bool, error) {
+			attempts++
+			return false, nil
+		})
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if exp, got := 60, attempts; exp != got {
+		t.Fatalf("expected %d attempts, got %d", exp, got)
+	}
+	if elapsed := clock.Now().Sub(start); elapsed != time.Minute {
+		t.Fatalf("expected to wait for a minute, got %v", elapsed)
+	}
+}
+
+func TestRetry_deadline(t *testing.T) {
+	for _, clock := range []dblock.Clock{dblock.SystemClock, dblock.OffsetClock(dblock.SystemClock, time.Hour)} {
+		// the ctx of the attempts reports the deadline to the drivers
+		err := dblock.Retry(context.Background(), clock, time.Minute, dblock.NoRetry(), func(ctx context.Context) (bool, error) {
+			if deadline, ok := ctx.Deadline(); !ok || time.Until(deadline) > time.Minute {
+				t.Errorf("expected the deadline within a minute, got %v, %v", deadline, ok)
+			}
+			return true, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
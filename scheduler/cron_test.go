@@ -0,0 +1,44 @@
+package scheduler_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bingoohuang/dblock/scheduler"
+)
+
+func TestCron(t *testing.T) {
+	from := time.Date(2023, 6, 15, 10, 30, 20, 0, time.UTC) // Thursday
+	for _, tc := range []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2023, 6, 15, 10, 31, 0, 0, time.UTC)},
+		{"*/20 * * * *", time.Date(2023, 6, 15, 10, 40, 0, 0, time.UTC)},
+		{"5 * * * *", time.Date(2023, 6, 15, 11, 5, 0, 0, time.UTC)},
+		{"0 9-18/3 * * *", time.Date(2023, 6, 15, 12, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2023, 6, 18, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * 1", time.Date(2023, 6, 19, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 31 2 1", time.Date(2024, 2, 5, 0, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2023, 6, 16, 0, 0, 0, 0, time.UTC)},
+		{"@every 90s", from.Add(90 * time.Second)},
+	} {
+		s, err := scheduler.Cron(tc.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.spec, err)
+		}
+		if got := s.Next(from); !got.Equal(tc.next) {
+			t.Errorf("%s: expected %v, got %v", tc.spec, tc.next, got)
+		}
+	}
+}
+
+func TestCron_invalid(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "a * * * *", "5-1 * * * *", "0 0 31 2 *", "0 0 30,31 2 *", "0 0 31 2,4,6 *"} {
+		if _, err := scheduler.Cron(spec); err == nil {
+			t.Errorf("%q: expected error", spec)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+// Package scheduler runs the scheduled tasks on only one node at a time, like shedlock-spring.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+)
+
+// Task is a scheduled task.
+type Task struct {
+	// Name is the unique name of the task, which is also used as the lock key.
+	Name string
+	// Schedule tells when the task runs.
+	Schedule Schedule
+	// Run is the function of the task, the ctx is cancelled when LockAtMostFor passed.
+	Run func(ctx context.Context) error
+	// LockAtMostFor is how long the lock is kept at most, in case the node dies, which is the TTL of the lock.
+	LockAtMostFor time.Duration
+	// LockAtLeastFor is how long the lock is kept at least, even if the task finishes earlier,
+	// to prevent the fast tasks from running more than once on the nodes with clock skew.
+	LockAtLeastFor time.Duration
+}
+
+// Scheduler runs the registered tasks.
+type Scheduler struct {
+	client dblock.Client
+	// ErrorHandler is called with the errors of the task runs, they are logged by Logger if nil.
+	ErrorHandler func(task string, err error)
+	// Logger logs the errors of the task runs if ErrorHandler is nil, slog.Default() is used if nil.
+	Logger *slog.Logger
+	// Clock tells the time of the schedules and LockAtLeastFor, dblock.SystemClock is used if nil,
+	// it should be the same as the clock of the client.
+	Clock dblock.Clock
+
+	mu    sync.Mutex
+	tasks []Task
+}
+
+// New creates a new Scheduler with the lock client.
+func New(client dblock.Client) *Scheduler {
+	return &Scheduler{client: client}
+}
+
+// Add registers the task, it starts to run when Run is called.
+func (s *Scheduler) Add(task Task) error {
+	switch {
+	case task.Name == "":
+		return errors.New("scheduler: task name is empty")
+	case task.Schedule == nil || task.Run == nil:
+		return fmt.Errorf("scheduler: task %s has no schedule or run function", task.Name)
+	case task.LockAtMostFor <= 0:
+		return fmt.Errorf("scheduler: task %s has no LockAtMostFor", task.Name)
+	case task.LockAtLeastFor > task.LockAtMostFor:
+		return fmt.Errorf("scheduler: task %s LockAtLeastFor is greater than LockAtMostFor", task.Name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, t := range s.tasks {
+		if t.Name == task.Name {
+			return fmt.Errorf("scheduler: task %s is added already", task.Name)
+		}
+	}
+	s.tasks = append(s.tasks, task)
+	return nil
+}
+
+// Run runs the registered tasks on their schedules, until the ctx is done,
+// and waits for the running tasks to finish.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.mu.Lock()
+	tasks := append([]Task(nil), s.tasks...)
+	s.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		wg.Add(1)
+		go func(task Task) {
+			defer wg.Done()
+			s.loop(ctx, task)
+		}(task)
+	}
+	wg.Wait()
+}
+
+func (s *Scheduler) loop(ctx context.Context, task Task) {
+	clock := s.Clock
+	if clock == nil {
+		clock = dblock.SystemClock
+	}
+
+	for {
+		now := clock.Now()
+		next := task.Schedule.Next(now)
+		if next.IsZero() {
+			return
+		}
+
+		timer := clock.NewTimer(next.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+
+		if _, err := runLocked(ctx, clock, s.client, task.Name, task.LockAtMostFor, task.LockAtLeastFor, task.Run); err != nil {
+			s.handleError(task.Name, err)
+		}
+	}
+}
+
+func (s *Scheduler) handleError(task string, err error) {
+	if s.ErrorHandler != nil {
+		s.ErrorHandler(task, err)
+		return
+	}
+	logger := s.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	logger.Error("scheduler: task failed", "task", task, "error", err)
+}
+
+// RunLocked runs fn once if the lock of the key is obtained, and reports whether it runs.
+// The lock is kept for lockAtLeastFor at least, and lockAtMostFor at most.
+func RunLocked(ctx context.Context, client dblock.Client, key string, lockAtMostFor, lockAtLeastFor time.Duration,
+	fn func(ctx context.Context) error,
+) (bool, error) {
+	return runLocked(ctx, dblock.SystemClock, client, key, lockAtMostFor, lockAtLeastFor, fn)
+}
+
+func runLocked(ctx context.Context, clock dblock.Clock, client dblock.Client, key string, lockAtMostFor, lockAtLeastFor time.Duration,
+	fn func(ctx context.Context) error,
+) (bool, error) {
+	start := clock.Now()
+	lock, err := client.Obtain(ctx, key, lockAtMostFor, dblock.WithRetryStrategy(dblock.NoRetry()))
+	if errors.Is(err, dblock.ErrNotObtained) {
+		// running on the other node
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	lockCtx, cancel := lock.Context(ctx)
+	defer cancel()
+
+	err = fn(lockCtx)
+
+	releaseCtx, cancelRelease := context.WithTimeout(context.Background(), lockAtMostFor)
+	defer cancelRelease()
+
+	var releaseErr error
+	if rest := lockAtLeastFor - clock.Now().Sub(start); rest > 0 {
+		// keep the lock until lockAtLeastFor passed, it expires then.
+		releaseErr = lock.Refresh(releaseCtx, rest)
+	} else {
+		releaseErr = lock.Release(releaseCtx)
+	}
+	if err == nil && releaseErr != nil && !errors.Is(releaseErr, dblock.ErrNotObtained) && !errors.Is(releaseErr, dblock.ErrLockNotHeld) {
+		err = releaseErr
+	}
+	return true, err
+}
@@ -0,0 +1,139 @@
+package scheduler_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+	"github.com/bingoohuang/dblock/clocktest"
+	"github.com/bingoohuang/dblock/dblocktest"
+	"github.com/bingoohuang/dblock/scheduler"
+)
+
+func TestRunLocked(t *testing.T) {
+	ctx := context.Background()
+	clock := clocktest.NewFakeClock(time.Now())
+	client := dblocktest.NewClient(dblock.WithClock(clock))
+
+	ran, err := scheduler.RunLocked(ctx, client, "task", time.Minute, 0, func(context.Context) error { return nil })
+	if err != nil || !ran {
+		t.Fatalf("expected ran, got %v, %v", ran, err)
+	}
+	// released at once without lockAtLeastFor
+	if client.Held("task") {
+		t.Fatal("expected released")
+	}
+
+	ran, err = scheduler.RunLocked(ctx, client, "task", time.Minute, 50*time.Millisecond, func(context.Context) error { return nil })
+	if err != nil || !ran {
+		t.Fatalf("expected ran, got %v, %v", ran, err)
+	}
+	// kept until lockAtLeastFor passed
+	if !client.Held("task") {
+		t.Fatal("expected held")
+	}
+	if ran, err := scheduler.RunLocked(ctx, client, "task", time.Minute, 0, func(context.Context) error { return nil }); err != nil || ran {
+		t.Fatalf("expected not ran, got %v, %v", ran, err)
+	}
+
+	clock.Advance(60 * time.Millisecond)
+	if client.Held("task") {
+		t.Fatal("expected expired")
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Now())
+	client := &obtainedClient{Client: dblocktest.NewClient(dblock.WithClock(clock)), obtained: make(chan struct{})}
+	var runs int32
+
+	// two nodes run the same task
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		s := scheduler.New(client)
+		s.Clock = clock
+		if err := s.Add(scheduler.Task{
+			Name:           "task",
+			Schedule:       scheduler.Every(100 * time.Millisecond),
+			Run:            func(context.Context) error { atomic.AddInt32(&runs, 1); return nil },
+			LockAtMostFor:  time.Minute,
+			LockAtLeastFor: 50 * time.Millisecond,
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.Run(ctx)
+		}()
+	}
+	clock.WaitTimers(2)
+
+	for i := int32(1); i <= 3; i++ {
+		clock.Advance(100 * time.Millisecond)
+		<-client.obtained
+		<-client.obtained
+		// the next schedules of both nodes, and the lock kept for LockAtLeastFor
+		clock.WaitTimers(3)
+
+		if got := atomic.LoadInt32(&runs); got != i {
+			t.Fatalf("expected %d runs, got %d", i, got)
+		}
+	}
+	cancel()
+	wg.Wait()
+}
+
+func TestScheduler_Logger(t *testing.T) {
+	clock := clocktest.NewFakeClock(time.Now())
+	var buf bytes.Buffer
+	s := scheduler.New(dblocktest.NewClient(dblock.WithClock(clock)))
+	s.Clock = clock
+	s.Logger = slog.New(slog.NewTextHandler(&buf, nil))
+	if err := s.Add(scheduler.Task{
+		Name:          "task",
+		Schedule:      scheduler.Every(100 * time.Millisecond),
+		Run:           func(context.Context) error { return errors.New("boom") },
+		LockAtMostFor: time.Minute,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(ctx)
+	}()
+	clock.WaitTimers(1)
+	clock.Advance(100 * time.Millisecond)
+	// the next schedule after the error is handled
+	clock.WaitTimers(1)
+	cancel()
+	<-done
+
+	if log := buf.String(); !strings.Contains(log, "scheduler: task failed") || !strings.Contains(log, "task=task error=boom") {
+		t.Fatalf("expected the error logged, got %s", log)
+	}
+}
+
+// obtainedClient tells when the Obtain calls return.
+type obtainedClient struct {
+	dblock.Client
+	obtained chan struct{}
+}
+
+func (c *obtainedClient) Obtain(ctx context.Context, key string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
+	lock, err := c.Client.Obtain(ctx, key, ttl, optionsFns...)
+	c.obtained <- struct{}{}
+	return lock, err
+}
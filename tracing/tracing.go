@@ -0,0 +1,173 @@
+// Package tracing creates the spans of the lock operations of any dblock.Client,
+// including each attempt of obtaining and the backoff slept between, through a Tracer like OpenTelemetry's.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+)
+
+// Tracer starts the spans, it is easy to adapt to an OpenTelemetry trace.Tracer, see the README.
+type Tracer interface {
+	// Start creates a span as the child of the span in ctx if any, and returns a ctx containing the span.
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is the span started by a Tracer.
+type Span interface {
+	// SetAttributes sets the attributes of the span.
+	SetAttributes(attrs ...Attribute)
+	// RecordError records the error of the operation of the span.
+	RecordError(err error)
+	// End completes the span.
+	End()
+}
+
+// Attribute is a key value pair of the span, the value is a string, int, int64, bool or float64,
+// like attribute.KeyValue of OpenTelemetry.
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// The attribute keys of the spans.
+const (
+	AttrKey     = "dblock.key"
+	AttrBackend = "dblock.backend"
+	AttrResult  = "dblock.result"
+	AttrAttempt = "dblock.attempt"
+	AttrBackoff = "dblock.backoff_ms"
+	AttrWoken   = "dblock.woken"
+	AttrTTL     = "dblock.ttl_ms"
+)
+
+// The results of the operations.
+const (
+	ResultOK          = "ok"
+	ResultNotObtained = "not_obtained"
+	ResultNotHeld     = "not_held"
+	ResultTimeout     = "timeout"
+	ResultError       = "error"
+)
+
+// Wrap wraps the client to create the spans for Obtain, View, and the Refresh, Release and TTL of the obtained locks,
+// backend is the attribute of the client, like "redis" or "mysql".
+func Wrap(client dblock.Client, backend string, tracer Tracer) dblock.Client {
+	return &wrappedClient{Client: client, backend: backend, tracer: tracer}
+}
+
+type wrappedClient struct {
+	dblock.Client
+	backend string
+	tracer  Tracer
+}
+
+func (c *wrappedClient) start(ctx context.Context, name, key string, attrs ...Attribute) (context.Context, Span) {
+	attrs = append([]Attribute{{Key: AttrKey, Value: key}, {Key: AttrBackend, Value: c.backend}}, attrs...)
+	return c.tracer.Start(ctx, name, attrs...)
+}
+
+func (c *wrappedClient) View(ctx context.Context, key string) (dblock.LockView, error) {
+	ctx, span := c.start(ctx, "dblock.View", key)
+	defer span.End()
+
+	view, err := c.Client.View(ctx, key)
+	setResult(span, err)
+	return view, err
+}
+
+// Obtain creates the span dblock.Obtain, with the child spans dblock.Attempt for each attempt of obtaining,
+// and dblock.Backoff for each backoff slept between.
+func (c *wrappedClient) Obtain(ctx context.Context, key string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
+	ctx, span := c.start(ctx, "dblock.Obtain", key, Attribute{Key: AttrTTL, Value: ttl.Milliseconds()})
+	defer span.End()
+
+	// the hooks run in sequence, at most one child span is open at a time.
+	var child Span
+	obtainCtx := dblock.WithRetryTrace(ctx, &dblock.RetryTrace{
+		Attempt: func(attempt int) {
+			_, child = c.start(ctx, "dblock.Attempt", key, Attribute{Key: AttrAttempt, Value: attempt})
+		},
+		AttemptDone: func(_ int, ok bool, err error) {
+			if err == nil && !ok {
+				// contended, not an error
+				child.SetAttributes(Attribute{Key: AttrResult, Value: ResultNotObtained})
+			} else {
+				setResult(child, err)
+			}
+			child.End()
+		},
+		Wait: func(backoff time.Duration) {
+			_, child = c.start(ctx, "dblock.Backoff", key, Attribute{Key: AttrBackoff, Value: backoff.Milliseconds()})
+		},
+		WaitDone: func(woken bool) {
+			child.SetAttributes(Attribute{Key: AttrWoken, Value: woken})
+			child.End()
+		},
+	})
+
+	lock, err := c.Client.Obtain(obtainCtx, key, ttl, optionsFns...)
+	setResult(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return &wrappedLock{Lock: lock, client: c, key: key}, nil
+}
+
+type wrappedLock struct {
+	dblock.Lock
+	client *wrappedClient
+	key    string
+}
+
+func (l *wrappedLock) Holds() int { return dblock.Holds(l.Lock) }
+
+func (l *wrappedLock) TTL(ctx context.Context) (time.Duration, error) {
+	ctx, span := l.client.start(ctx, "dblock.TTL", l.key)
+	defer span.End()
+
+	ttl, err := l.Lock.TTL(ctx)
+	setResult(span, err)
+	return ttl, err
+}
+
+func (l *wrappedLock) Refresh(ctx context.Context, ttl time.Duration) error {
+	ctx, span := l.client.start(ctx, "dblock.Refresh", l.key, Attribute{Key: AttrTTL, Value: ttl.Milliseconds()})
+	defer span.End()
+
+	err := l.Lock.Refresh(ctx, ttl)
+	setResult(span, err)
+	return err
+}
+
+func (l *wrappedLock) Release(ctx context.Context) error {
+	ctx, span := l.client.start(ctx, "dblock.Release", l.key)
+	defer span.End()
+
+	err := l.Lock.Release(ctx)
+	setResult(span, err)
+	return err
+}
+
+// setResult sets the result of the span, and records the error if any.
+func setResult(span Span, err error) {
+	result := ResultOK
+	switch {
+	case err == nil:
+	case errors.Is(err, dblock.ErrNotObtained):
+		result = ResultNotObtained
+	case errors.Is(err, dblock.ErrLockNotHeld):
+		result = ResultNotHeld
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		result = ResultTimeout
+	default:
+		result = ResultError
+	}
+	span.SetAttributes(Attribute{Key: AttrResult, Value: result})
+	if err != nil {
+		span.RecordError(err)
+	}
+}
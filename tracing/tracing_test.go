@@ -0,0 +1,125 @@
+package tracing_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bingoohuang/dblock"
+	"github.com/bingoohuang/dblock/tracing"
+)
+
+func TestWrap(t *testing.T) {
+	ctx := context.Background()
+	tracer := &recordTracer{}
+	client := tracing.Wrap(&flakyClient{contended: 2}, "mem", tracer)
+
+	lock, err := client.Obtain(ctx, "key", time.Minute, dblock.WithRetryStrategy(dblock.LinearBackoff(time.Millisecond)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the hold count is forwarded by the wrapped lock
+	if exp, got := 2, dblock.Holds(lock); exp != got {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+	if err := lock.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []string{
+		"dblock.Attempt attempt=1 result=not_obtained",
+		"dblock.Backoff backoff_ms=1 woken=false",
+		"dblock.Attempt attempt=2 result=not_obtained",
+		"dblock.Backoff backoff_ms=1 woken=false",
+		"dblock.Attempt attempt=3 result=ok",
+		"dblock.Obtain ttl_ms=60000 result=ok",
+		"dblock.Release result=ok",
+	}
+	if got := tracer.get(); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("expected %q, got %q", exp, got)
+	}
+}
+
+// recordTracer records the ended spans as strings of the name and the attributes except the key and backend.
+type recordTracer struct {
+	mu    sync.Mutex
+	spans []string
+}
+
+func (r *recordTracer) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.spans...)
+}
+
+func (r *recordTracer) Start(ctx context.Context, name string, attrs ...tracing.Attribute) (context.Context, tracing.Span) {
+	s := &recordSpan{tracer: r, name: name}
+	s.SetAttributes(attrs...)
+	return ctx, s
+}
+
+type recordSpan struct {
+	tracer *recordTracer
+	name   string
+}
+
+func (s *recordSpan) SetAttributes(attrs ...tracing.Attribute) {
+	for _, a := range attrs {
+		if a.Key != tracing.AttrKey && a.Key != tracing.AttrBackend {
+			s.name += fmt.Sprintf(" %s=%v", a.Key[len("dblock."):], a.Value)
+		}
+	}
+}
+
+func (s *recordSpan) RecordError(err error) { s.name += " error=" + err.Error() }
+
+func (s *recordSpan) End() {
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.tracer.spans = append(s.tracer.spans, s.name)
+}
+
+// flakyClient obtains the lock after the contended attempts.
+type flakyClient struct {
+	contended int
+}
+
+func (c *flakyClient) View(_ context.Context, key string) (dblock.LockView, error) {
+	return dblock.LockView{Key: key}, nil
+}
+
+func (c *flakyClient) Obtain(ctx context.Context, _ string, ttl time.Duration, optionsFns ...dblock.OptionsFn) (dblock.Lock, error) {
+	opt, err := dblock.ParseOptions(optionsFns...)
+	if err != nil {
+		return nil, err
+	}
+
+	attempts := 0
+	err = dblock.Retry(ctx, opt.Clock, ttl, opt.GetRetryStrategy(), func(context.Context) (bool, error) {
+		attempts++
+		return attempts > c.contended, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &flakyLock{lifetime: dblock.NewLifetime(opt.Clock, time.Now().Add(ttl))}, nil
+}
+
+type flakyLock struct {
+	lifetime *dblock.Lifetime
+}
+
+func (l *flakyLock) Token() string                                { return "" }
+func (l *flakyLock) Metadata() string                             { return "" }
+func (l *flakyLock) Fence() uint64                                { return 0 }
+func (l *flakyLock) Holds() int                                   { return 2 }
+func (l *flakyLock) TTL(context.Context) (time.Duration, error)   { return 0, nil }
+func (l *flakyLock) Refresh(context.Context, time.Duration) error { return nil }
+func (l *flakyLock) Release(context.Context) error                { l.lifetime.Lose(); return nil }
+func (l *flakyLock) Done() <-chan struct{}                        { return l.lifetime.Done() }
+func (l *flakyLock) Context(p context.Context) (context.Context, context.CancelFunc) {
+	return l.lifetime.Context(p)
+}
@@ -4,6 +4,11 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"io"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"time"
 )
 
 // RandomToken generates a random token.
@@ -15,3 +20,46 @@ func RandomToken() (string, error) {
 
 	return base64.RawURLEncoding.EncodeToString(tmp), nil
 }
+
+// UniqueKeys returns the sorted keys without duplicates,
+// obtaining multiple keys in the same order avoids deadlocks.
+func UniqueKeys(keys []string) []string {
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+
+	unique := sorted[:0]
+	for i, key := range sorted {
+		if i == 0 || key != sorted[i-1] {
+			unique = append(unique, key)
+		}
+	}
+	return unique
+}
+
+// Hostname is the host name of the holders recorded in the locks.
+var Hostname = func() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err.Error()
+	}
+
+	return hostname
+}()
+
+// Pid is the process id of the holders recorded in the locks.
+var Pid = os.Getpid()
+
+// AdminRecord returns the metadata recording who did the admin action on a lock and why,
+// like "stolen by alice@host1 (pid 123) at 2023-08-02T23:15:05+08:00: host2 died", at the time of the clock,
+// SystemClock if nil.
+func AdminRecord(clock Clock, action, reason string) string {
+	if clock == nil {
+		clock = SystemClock
+	}
+	who := Hostname
+	if u, err := user.Current(); err == nil {
+		who = u.Username + "@" + who
+	}
+
+	return action + " by " + who + " (pid " + strconv.Itoa(Pid) + ") at " + clock.Now().Format(time.RFC3339) + ": " + reason
+}